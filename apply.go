@@ -0,0 +1,104 @@
+package dbtesting
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+)
+
+type ForeignKeyCycleError struct {
+	Tables []string
+}
+
+func (e *ForeignKeyCycleError) Error() string {
+	return "foreign key cycle between tables: " + strings.Join(e.Tables, ", ")
+}
+
+// applyOrder sorts the tables so that every table comes after the tables it
+// references, and reports which tables are referenced by others, in the
+// snapshot or not.
+func applyOrder(db Executor, dialect Dialect, tables []string) ([]string, map[string]bool, error) {
+	in := make(map[string]bool, len(tables))
+	for _, t := range tables {
+		in[t] = true
+	}
+
+	parents := make(map[string]map[string]bool, len(tables))
+	referenced := make(map[string]bool)
+	for _, t := range tables {
+		fks, err := dialect.ForeignKeys(db, t)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		parents[t] = make(map[string]bool)
+		for _, fk := range fks {
+			if fk.ReferencedTableName != t && in[fk.ReferencedTableName] {
+				parents[t][fk.ReferencedTableName] = true
+			}
+		}
+
+		children, err := dialect.ReferencingTables(db, t)
+		if err != nil {
+			return nil, nil, err
+		}
+		referenced[t] = len(children) > 0
+	}
+
+	order := make([]string, 0, len(tables))
+	done := make(map[string]bool, len(tables))
+	for len(order) < len(tables) {
+		progress := false
+		for _, t := range tables {
+			if done[t] {
+				continue
+			}
+
+			ready := true
+			for p := range parents[t] {
+				if !done[p] {
+					ready = false
+					break
+				}
+			}
+
+			if ready {
+				order = append(order, t)
+				done[t] = true
+				progress = true
+			}
+		}
+
+		if !progress {
+			var cycle []string
+			for _, t := range tables {
+				if !done[t] {
+					cycle = append(cycle, t)
+				}
+			}
+			sort.Strings(cycle)
+			return nil, nil, &ForeignKeyCycleError{Tables: cycle}
+		}
+	}
+
+	return order, referenced, nil
+}
+
+// connExecutor pins a session of a *sql.DB, so that session variables keep
+// their value between statements.
+type connExecutor struct {
+	*sql.Conn
+}
+
+func (c connExecutor) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.ExecContext(context.Background(), query, args...)
+}
+
+func (c connExecutor) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.QueryContext(context.Background(), query, args...)
+}
+
+func (c connExecutor) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.QueryRowContext(context.Background(), query, args...)
+}
@@ -0,0 +1,671 @@
+package dbtesting
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Codec converts the values of a scan type to plain values (nil, bool,
+// string, numbers and maps) that every snapshot encoding can represent, and
+// back. Compare compares two values of the type in checks. Nil functions fall
+// back to the behaviour of the kind of the type, to a JSON round trip, and to
+// ==.
+type Codec struct {
+	Encode  func(v interface{}) (interface{}, error)
+	Decode  func(v interface{}, typ reflect.Type) (interface{}, error)
+	Compare Comparator
+}
+
+var registry = struct {
+	sync.RWMutex
+	byName  map[string]reflect.Type
+	codecs  map[reflect.Type]Codec
+	dbTypes map[string]reflect.Type
+}{
+	byName:  make(map[string]reflect.Type),
+	codecs:  make(map[reflect.Type]Codec),
+	dbTypes: make(map[string]reflect.Type),
+}
+
+// RegisterScanType makes typ usable as the scan type of columns, saved in
+// snapshots by its name, with the encoding and comparison of c. It panics if
+// another type of the same name is registered.
+func RegisterScanType(typ reflect.Type, c Codec) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if typ == nil {
+		panic("dbtesting: RegisterScanType of nil type")
+	}
+	if other, ok := registry.byName[typ.String()]; ok && other != typ {
+		panic("dbtesting: RegisterScanType of two types named " + typ.String())
+	}
+
+	registry.byName[typ.String()] = typ
+	registry.codecs[typ] = c
+}
+
+// RegisterDatabaseType makes the columns of the database type, as given by
+// sql.ColumnType.DatabaseTypeName, scan into typ whatever the dialect, typ
+// being registered by RegisterScanType. Database types are matched ignoring
+// case.
+func RegisterDatabaseType(databaseType string, typ reflect.Type) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if _, ok := registry.codecs[typ]; !ok {
+		panic("dbtesting: RegisterDatabaseType of unregistered type " + typ.String())
+	}
+	registry.dbTypes[strings.ToUpper(databaseType)] = typ
+}
+
+func scanTypeByName(name string) (reflect.Type, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	typ, ok := registry.byName[name]
+	return typ, ok
+}
+
+func scanTypeOfDatabaseType(databaseType string) (reflect.Type, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	typ, ok := registry.dbTypes[strings.ToUpper(databaseType)]
+	return typ, ok
+}
+
+func registered(typ reflect.Type) bool {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	_, ok := registry.codecs[typ]
+	return ok
+}
+
+func comparatorOf(typ reflect.Type) Comparator {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	return registry.codecs[typ].Compare
+}
+
+var builtinCodecs = map[reflect.Type]Codec{
+	reflect.TypeOf(sql.NullString{}): {
+		Encode: func(v interface{}) (interface{}, error) {
+			s := v.(sql.NullString)
+			if !s.Valid {
+				return nil, nil
+			}
+			return s.String, nil
+		},
+		Decode: func(v interface{}, _ reflect.Type) (interface{}, error) {
+			if v == nil {
+				return sql.NullString{}, nil
+			}
+			s, err := toString(v)
+			return sql.NullString{String: s, Valid: err == nil}, err
+		},
+	},
+	reflect.TypeOf(sql.NullInt64{}): {
+		Encode: func(v interface{}) (interface{}, error) {
+			n := v.(sql.NullInt64)
+			if !n.Valid {
+				return nil, nil
+			}
+			return n.Int64, nil
+		},
+		Decode: func(v interface{}, _ reflect.Type) (interface{}, error) {
+			if v == nil {
+				return sql.NullInt64{}, nil
+			}
+			n, err := toInt64(v)
+			return sql.NullInt64{Int64: n, Valid: err == nil}, err
+		},
+	},
+	reflect.TypeOf(sql.NullFloat64{}): {
+		Encode: func(v interface{}) (interface{}, error) {
+			f := v.(sql.NullFloat64)
+			if !f.Valid {
+				return nil, nil
+			}
+			return strconv.FormatFloat(f.Float64, 'f', -1, 64), nil
+		},
+		Decode: func(v interface{}, _ reflect.Type) (interface{}, error) {
+			if v == nil {
+				return sql.NullFloat64{}, nil
+			}
+			f, err := toFloat64(v)
+			return sql.NullFloat64{Float64: f, Valid: err == nil}, err
+		},
+	},
+	reflect.TypeOf(sql.NullBool{}): {
+		Encode: func(v interface{}) (interface{}, error) {
+			b := v.(sql.NullBool)
+			if !b.Valid {
+				return nil, nil
+			}
+			return b.Bool, nil
+		},
+		Decode: func(v interface{}, typ reflect.Type) (interface{}, error) {
+			if v == nil {
+				return sql.NullBool{}, nil
+			}
+			b, err := kindCodecs[reflect.Bool].Decode(v, reflect.TypeOf(false))
+			if err != nil {
+				return nil, err
+			}
+			return sql.NullBool{Bool: b.(bool), Valid: true}, nil
+		},
+	},
+	reflect.TypeOf(time.Time{}): {
+		Encode: func(v interface{}) (interface{}, error) {
+			return v.(time.Time).Format(time.RFC3339Nano), nil
+		},
+		Decode: func(v interface{}, _ reflect.Type) (interface{}, error) {
+			return toTime(v)
+		},
+		Compare: TimeEqual,
+	},
+	reflect.TypeOf(mysql.NullTime{}): {
+		Encode: func(v interface{}) (interface{}, error) {
+			t := v.(mysql.NullTime)
+			if !t.Valid {
+				return nil, nil
+			}
+			return t.Time.Format(time.RFC3339Nano), nil
+		},
+		Decode: func(v interface{}, _ reflect.Type) (interface{}, error) {
+			if v == nil {
+				return mysql.NullTime{}, nil
+			}
+			t, err := toTime(v)
+			return mysql.NullTime{Time: t, Valid: err == nil}, err
+		},
+		Compare: func(expect, actual interface{}) (string, bool) {
+			e, a := expect.(mysql.NullTime), actual.(mysql.NullTime)
+			if e.Valid != a.Valid || e.Valid && !e.Time.Equal(a.Time) {
+				return fmt.Sprintf("expect: %s, actual: %s", formatValue(expect), formatValue(actual)), false
+			}
+			return "", true
+		},
+	},
+	reflect.TypeOf(sql.NullTime{}): {
+		Encode: func(v interface{}) (interface{}, error) {
+			t := v.(sql.NullTime)
+			if !t.Valid {
+				return nil, nil
+			}
+			return t.Time.Format(time.RFC3339Nano), nil
+		},
+		Decode: func(v interface{}, _ reflect.Type) (interface{}, error) {
+			if v == nil {
+				return sql.NullTime{}, nil
+			}
+			t, err := toTime(v)
+			return sql.NullTime{Time: t, Valid: err == nil}, err
+		},
+		Compare: func(expect, actual interface{}) (string, bool) {
+			e, a := expect.(sql.NullTime), actual.(sql.NullTime)
+			if e.Valid != a.Valid || e.Valid && !e.Time.Equal(a.Time) {
+				return fmt.Sprintf("expect: %s, actual: %s", formatValue(expect), formatValue(actual)), false
+			}
+			return "", true
+		},
+	},
+	reflect.TypeOf(sql.RawBytes{}): {
+		Encode: func(v interface{}) (interface{}, error) {
+			if d, ok := v.(BlobDigest); ok {
+				return map[string]interface{}{"len": d.Len, "sha256": d.SHA256}, nil
+			}
+			b := v.(sql.RawBytes)
+			if b == nil {
+				return nil, nil
+			}
+			return encodeBytes(b), nil
+		},
+		Decode: func(v interface{}, _ reflect.Type) (interface{}, error) {
+			if v == nil {
+				return sql.RawBytes(nil), nil
+			}
+			if d, ok := decodeDigest(v); ok {
+				return d, nil
+			}
+			b, err := decodeBytes(v)
+			return sql.RawBytes(b), err
+		},
+		Compare: RawBytesEqual,
+	},
+}
+
+// kindCodecs handle the basic types, whatever their bit size.
+var kindCodecs = map[reflect.Kind]Codec{
+	reflect.String: {
+		Encode: func(v interface{}) (interface{}, error) {
+			return reflect.ValueOf(v).String(), nil
+		},
+		Decode: func(v interface{}, typ reflect.Type) (interface{}, error) {
+			s, err := toString(v)
+			return reflect.ValueOf(s).Convert(typ).Interface(), err
+		},
+	},
+	reflect.Bool: {
+		Encode: func(v interface{}) (interface{}, error) {
+			return reflect.ValueOf(v).Bool(), nil
+		},
+		Decode: func(v interface{}, typ reflect.Type) (interface{}, error) {
+			b, ok := v.(bool)
+			if !ok {
+				s, err := toString(v)
+				if err != nil {
+					return nil, err
+				}
+				b, err = strconv.ParseBool(s)
+				if err != nil {
+					return nil, err
+				}
+			}
+			return reflect.ValueOf(b).Convert(typ).Interface(), nil
+		},
+	},
+	reflect.Int64: {
+		Encode: func(v interface{}) (interface{}, error) {
+			return reflect.ValueOf(v).Int(), nil
+		},
+		Decode: func(v interface{}, typ reflect.Type) (interface{}, error) {
+			n, err := toInt64(v)
+			if err != nil {
+				return nil, err
+			}
+			rv := reflect.New(typ).Elem()
+			if rv.OverflowInt(n) {
+				return nil, fmt.Errorf("%d overflows %s", n, typ)
+			}
+			rv.SetInt(n)
+			return rv.Interface(), nil
+		},
+	},
+	reflect.Uint64: {
+		Encode: func(v interface{}) (interface{}, error) {
+			return reflect.ValueOf(v).Uint(), nil
+		},
+		Decode: func(v interface{}, typ reflect.Type) (interface{}, error) {
+			n, err := toUint64(v)
+			if err != nil {
+				return nil, err
+			}
+			rv := reflect.New(typ).Elem()
+			if rv.OverflowUint(n) {
+				return nil, fmt.Errorf("%d overflows %s", n, typ)
+			}
+			rv.SetUint(n)
+			return rv.Interface(), nil
+		},
+	},
+	reflect.Float64: {
+		// as exact decimal strings
+		Encode: func(v interface{}) (interface{}, error) {
+			rv := reflect.ValueOf(v)
+			return strconv.FormatFloat(rv.Float(), 'f', -1, rv.Type().Bits()), nil
+		},
+		Decode: func(v interface{}, typ reflect.Type) (interface{}, error) {
+			f, err := toFloat64(v)
+			return reflect.ValueOf(f).Convert(typ).Interface(), err
+		},
+	},
+}
+
+func init() {
+	for _, k := range []reflect.Kind{reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32} {
+		kindCodecs[k] = kindCodecs[reflect.Int64]
+	}
+	for _, k := range []reflect.Kind{reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32} {
+		kindCodecs[k] = kindCodecs[reflect.Uint64]
+	}
+	kindCodecs[reflect.Float32] = kindCodecs[reflect.Float64]
+
+	for _, v := range []interface{}{
+		"", false, int(0), int8(0), int16(0), int32(0), int64(0),
+		uint(0), uint8(0), uint16(0), uint32(0), uint64(0),
+		float32(0), float64(0),
+	} {
+		RegisterScanType(reflect.TypeOf(v), Codec{})
+	}
+	for typ, c := range builtinCodecs {
+		RegisterScanType(typ, c)
+	}
+}
+
+// codecOf completes the registered codec of typ with the one of its kind.
+func codecOf(typ reflect.Type) Codec {
+	registry.RLock()
+	c := registry.codecs[typ]
+	registry.RUnlock()
+
+	k := kindCodecs[typ.Kind()]
+	if c.Encode == nil {
+		c.Encode = k.Encode
+	}
+	if c.Decode == nil {
+		c.Decode = k.Decode
+	}
+	return c
+}
+
+func encodeValue(v interface{}, typ reflect.Type) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	c := codecOf(typ)
+	if c.Encode == nil {
+		return v, nil
+	}
+	return c.Encode(v)
+}
+
+func decodeValue(v interface{}, typ reflect.Type) (interface{}, error) {
+	c := codecOf(typ)
+	if c.Decode != nil {
+		return c.Decode(v, typ)
+	}
+
+	// round trip through json for the types without codec
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	p := reflect.New(typ)
+	err = json.Unmarshal(data, p.Interface())
+	if err != nil {
+		return nil, err
+	}
+	return p.Elem().Interface(), nil
+}
+
+// encodeBytes keeps printable UTF-8 as a string, and uses {"hex": "..."}
+// for anything else, or {"len": n, "sha256": "..."} beyond MaxInlineBlob.
+func encodeBytes(b []byte) interface{} {
+	if len(b) > MaxInlineBlob {
+		d := newBlobDigest(b)
+		return map[string]interface{}{"len": d.Len, "sha256": d.SHA256}
+	}
+	if isPrintable(b) {
+		return string(b)
+	}
+	return map[string]interface{}{"hex": hex.EncodeToString(b)}
+}
+
+func decodeBytes(v interface{}) ([]byte, error) {
+	switch vv := v.(type) {
+	case string:
+		return []byte(vv), nil
+	case []byte:
+		return vv, nil
+	case map[string]interface{}:
+		s, ok := vv["hex"].(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid bytes value: %v", v)
+		}
+		return hex.DecodeString(s)
+	case map[interface{}]interface{}:
+		s, ok := vv["hex"].(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid bytes value: %v", v)
+		}
+		return hex.DecodeString(s)
+	default:
+		return nil, fmt.Errorf("invalid bytes value: %v", v)
+	}
+}
+
+func decodeDigest(v interface{}) (BlobDigest, bool) {
+	m := map[string]interface{}{}
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		m = vv
+	case map[interface{}]interface{}:
+		for k, v := range vv {
+			m[fmt.Sprint(k)] = v
+		}
+	default:
+		return BlobDigest{}, false
+	}
+
+	sum, ok := m["sha256"].(string)
+	if !ok {
+		return BlobDigest{}, false
+	}
+	n, err := toInt64(m["len"])
+	if err != nil {
+		return BlobDigest{}, false
+	}
+	return BlobDigest{Len: int(n), SHA256: sum}, true
+}
+
+func isPrintable(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) && r != '\n' && r != '\r' && r != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+func toString(v interface{}) (string, error) {
+	switch vv := v.(type) {
+	case string:
+		return vv, nil
+	case json.Number:
+		return vv.String(), nil
+	case int, int64, uint64, float64, bool:
+		return fmt.Sprint(vv), nil
+	default:
+		return "", fmt.Errorf("invalid string value: %v", v)
+	}
+}
+
+func toInt64(v interface{}) (int64, error) {
+	switch vv := v.(type) {
+	case int:
+		return int64(vv), nil
+	case int64:
+		return vv, nil
+	case float64:
+		if vv != float64(int64(vv)) {
+			return 0, fmt.Errorf("invalid integer value: %v", v)
+		}
+		return int64(vv), nil
+	}
+
+	s, err := toString(v)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+func toUint64(v interface{}) (uint64, error) {
+	switch vv := v.(type) {
+	case uint64:
+		return vv, nil
+	case int, int64, float64:
+		n, err := toInt64(v)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid unsigned value: %v", v)
+		}
+		return uint64(n), nil
+	}
+
+	s, err := toString(v)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+func toFloat64(v interface{}) (float64, error) {
+	switch vv := v.(type) {
+	case float64:
+		return vv, nil
+	case int:
+		return float64(vv), nil
+	}
+
+	s, err := toString(v)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+func toTime(v interface{}) (time.Time, error) {
+	switch vv := v.(type) {
+	case time.Time:
+		return vv, nil
+	case string:
+		// hand-written fixtures may use the layouts of the database, in UTC
+		for _, layout := range []string{time.RFC3339Nano, wallClock, "2006-01-02"} {
+			t, err := time.Parse(layout, vv)
+			if err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("invalid time value: %v", v)
+	default:
+		return time.Time{}, fmt.Errorf("invalid time value: %v", v)
+	}
+}
+
+// successors lists the scan types which replaced the ones of older versions
+// for some database types, or for any when nil. Snapshots recorded with the older ones are
+// upgraded when checked, see upgradeScanTypes.
+var successors = []struct {
+	databaseTypes []string
+	old, new      reflect.Type
+}{
+	{[]string{"JSON"}, reflect.TypeOf(sql.RawBytes{}), reflect.TypeOf(sql.NullString{})},
+	{[]string{"JSON"}, reflect.TypeOf(sql.RawBytes{}), reflect.TypeOf("")},
+	{[]string{"BIT"}, reflect.TypeOf(sql.RawBytes{}), reflect.TypeOf(NullBits{})},
+	{[]string{"GEOMETRY"}, reflect.TypeOf(sql.RawBytes{}), reflect.TypeOf(Geometry{})},
+	{[]string{"DATE"}, reflect.TypeOf(mysql.NullTime{}), reflect.TypeOf(NullDate{})},
+	{[]string{"DATE"}, reflect.TypeOf(time.Time{}), reflect.TypeOf(NullDate{})},
+	{[]string{"DATE"}, reflect.TypeOf(sql.RawBytes{}), reflect.TypeOf(NullDate{})},
+	{[]string{"TIME"}, reflect.TypeOf(sql.RawBytes{}), reflect.TypeOf(NullDuration{})},
+	{[]string{"TIME"}, reflect.TypeOf(mysql.NullTime{}), reflect.TypeOf(NullDuration{})},
+	{[]string{"YEAR"}, reflect.TypeOf(sql.NullInt64{}), reflect.TypeOf(NullYear{})},
+	{[]string{"YEAR"}, reflect.TypeOf(int16(0)), reflect.TypeOf(NullYear{})},
+	{[]string{"YEAR"}, reflect.TypeOf(uint16(0)), reflect.TypeOf(NullYear{})},
+	{[]string{"YEAR"}, reflect.TypeOf(sql.RawBytes{}), reflect.TypeOf(NullYear{})},
+	// the time columns of SQLite, whatever their declared type
+	{nil, reflect.TypeOf(mysql.NullTime{}), reflect.TypeOf(sql.NullTime{})},
+}
+
+func successorOf(databaseType string, old, new reflect.Type) bool {
+	for _, s := range successors {
+		if s.old != old || s.new != new {
+			continue
+		}
+		if s.databaseTypes == nil {
+			return true
+		}
+		for _, typ := range s.databaseTypes {
+			if strings.EqualFold(typ, databaseType) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upgradeScanTypes gives expect with the columns recorded by older versions
+// converted to the scan types of actual, when these replaced them.
+func upgradeScanTypes(expect, actual *Result) (*Result, error) {
+	if len(expect.colType) != len(actual.colType) {
+		return expect, nil
+	}
+
+	var upgraded *Result
+	for j, c := range expect.colType {
+		a := actual.colType[j]
+		if c.scanType == a.scanType || c.databaseType != a.databaseType || !successorOf(c.databaseType, c.scanType, a.scanType) {
+			continue
+		}
+
+		if upgraded == nil {
+			r := *expect
+			r.colType = append([]*ColType(nil), expect.colType...)
+			r.data = make([][]interface{}, len(expect.data))
+			for i, row := range expect.data {
+				r.data[i] = append([]interface{}(nil), row...)
+			}
+			upgraded = &r
+		}
+
+		col := *c
+		col.scanType = a.scanType
+		col.setTimeSemantics()
+		upgraded.colType[j] = &col
+
+		for i, row := range upgraded.data {
+			v, err := convertScanType(row[j], col.scanType)
+			if err != nil {
+				return nil, fmt.Errorf("result %s: row %d col %s: upgrade from %s: %s", expect.name, i, c.name, c.scanType, err)
+			}
+			row[j] = normalizeCell(v, &col)
+		}
+	}
+
+	if upgraded == nil {
+		return expect, nil
+	}
+	return upgraded, nil
+}
+
+// convertScanType scans the driver value of v into typ, as if read from the
+// database.
+func convertScanType(v interface{}, typ reflect.Type) (interface{}, error) {
+	if _, ok := v.(omitted); ok {
+		return v, nil
+	}
+
+	dv, err := driver.DefaultParameterConverter.ConvertValue(v)
+	if err != nil {
+		return nil, err
+	}
+
+	p := reflect.New(typ)
+	if s, ok := p.Interface().(sql.Scanner); ok {
+		err = s.Scan(dv)
+		return p.Elem().Interface(), err
+	}
+
+	switch dvv := dv.(type) {
+	case []byte:
+		if typ.Kind() != reflect.String {
+			return nil, fmt.Errorf("can not convert %v to %s", formatValue(v), typ)
+		}
+		p.Elem().Set(reflect.ValueOf(string(dvv)).Convert(typ))
+	default:
+		rv := reflect.ValueOf(dv)
+		if !rv.IsValid() || !rv.Type().ConvertibleTo(typ) {
+			return nil, fmt.Errorf("can not convert %v to %s", formatValue(v), typ)
+		}
+		p.Elem().Set(rv.Convert(typ))
+	}
+	return p.Elem().Interface(), nil
+}
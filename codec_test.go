@@ -0,0 +1,137 @@
+package dbtesting
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMarshalV2(t *testing.T) {
+	ts := time.Date(2018, 12, 1, 9, 0, 0, 500, time.UTC)
+	types := []interface{}{int32(0), sql.NullString{}, sql.RawBytes{}, sql.RawBytes{}, mysql.NullTime{}, uint64(0)}
+	names := []string{"id", "name", "text", "bin", "at", "id"}
+
+	cols := make([]*ColType, len(types))
+	for i, v := range types {
+		cols[i] = &ColType{name: names[i], scanType: reflect.TypeOf(v)}
+	}
+
+	r := &Result{
+		ResultType: ResultType{name: "t", isTable: true, colType: cols},
+		data: [][]interface{}{
+			{int32(1), sql.NullString{String: "a", Valid: true}, sql.RawBytes("héllo"), sql.RawBytes{0, 1, 255}, mysql.NullTime{Time: ts, Valid: true}, uint64(1 << 63)},
+			{int32(2), sql.NullString{}, sql.RawBytes(nil), sql.RawBytes{}, mysql.NullTime{}, uint64(0)},
+		},
+	}
+
+	data, err := Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []string{`"name": null`, `"text": "héllo"`, `"hex": "0001ff"`, `"at": "2018-12-01T09:00:00.0000005Z"`, `"id#2": 9223372036854775808`} {
+		if !strings.Contains(string(data), s) {
+			t.Errorf("expect %s in:\n%s", s, data)
+		}
+	}
+
+	r2, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := DiffResult(r, r2); d != nil {
+		t.Errorf("unexpected diff: %s", (&SnapshotDiff{Results: []*ResultDiff{d}}).String())
+	}
+}
+
+func TestUnmarshalV1(t *testing.T) {
+	data := `{
+  "cols": [
+    {"Name": "id", "DatabaseType": "INT", "ScanType": "int64"},
+    {"Name": "name", "DatabaseType": "VARCHAR", "Nullable": true, "ScanType": "sql.NullString"}
+  ],
+  "data": [[1, {"String": "a", "Valid": true}], [2, {"String": "", "Valid": false}]],
+  "isTable": true,
+  "name": "t"
+}`
+
+	r, err := Unmarshal([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := [][]interface{}{
+		{int64(1), sql.NullString{String: "a", Valid: true}},
+		{int64(2), sql.NullString{}},
+	}
+	if !reflect.DeepEqual(r.data, expect) {
+		t.Errorf("unexpected data: %v", r.data)
+	}
+}
+
+type testMoney struct {
+	cents int64
+}
+
+func TestRegisterScanType(t *testing.T) {
+	typ := reflect.TypeOf(testMoney{})
+	RegisterScanType(typ, Codec{
+		Encode: func(v interface{}) (interface{}, error) {
+			m := v.(testMoney)
+			return fmt.Sprintf("%d.%02d", m.cents/100, m.cents%100), nil
+		},
+		Decode: func(v interface{}, _ reflect.Type) (interface{}, error) {
+			var units, cents int64
+			_, err := fmt.Sscanf(v.(string), "%d.%d", &units, &cents)
+			return testMoney{units*100 + cents}, err
+		},
+		Compare: func(expect, actual interface{}) (string, bool) {
+			// only whole units matter
+			if expect.(testMoney).cents/100 != actual.(testMoney).cents/100 {
+				return fmt.Sprintf("expect: %v, actual: %v", expect, actual), false
+			}
+			return "", true
+		},
+	})
+
+	r := &Result{
+		ResultType: ResultType{name: "t", colType: []*ColType{
+			{name: "price", scanType: typ},
+			{name: "paid", scanType: reflect.TypeOf(sql.NullBool{})},
+		}},
+		data: [][]interface{}{
+			{testMoney{123}, sql.NullBool{Bool: true, Valid: true}},
+			{testMoney{5}, sql.NullBool{}},
+		},
+	}
+
+	data, err := Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"price": "1.23"`) || !strings.Contains(string(data), `"ScanType": "dbtesting.testMoney"`) {
+		t.Errorf("unexpected encoding:\n%s", data)
+	}
+
+	r2, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r.data, r2.data) {
+		t.Errorf("unexpected data: %v", r2.data)
+	}
+
+	r2.data[0][0] = testMoney{199}
+	if d := DiffResult(r, r2); d != nil {
+		t.Errorf("registered comparator should be used: %s", (&SnapshotDiff{Results: []*ResultDiff{d}}).String())
+	}
+
+	_, err = Unmarshal([]byte(`{"version": 2, "cols": [{"Name": "x", "ScanType": "unknown.Type"}], "rows": []}`))
+	if err == nil || !strings.Contains(err.Error(), "RegisterScanType") {
+		t.Errorf("expect unsupported scan type, got %v", err)
+	}
+}
@@ -0,0 +1,358 @@
+package dbtesting
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NullBits is the scan type of BIT columns, saved as integers. Bitstrings
+// like "b'0101'" are accepted when loading.
+type NullBits struct {
+	Bits  uint64
+	Valid bool
+}
+
+func (b *NullBits) Scan(v interface{}) error {
+	switch vv := v.(type) {
+	case nil:
+		*b = NullBits{}
+	case int64:
+		*b = NullBits{Bits: uint64(vv), Valid: true}
+	case []byte:
+		// BIT values come as big-endian bytes
+		if len(vv) > 8 {
+			return fmt.Errorf("BIT value of %d bytes overflows uint64", len(vv))
+		}
+		n := uint64(0)
+		for _, c := range vv {
+			n = n<<8 | uint64(c)
+		}
+		*b = NullBits{Bits: n, Valid: true}
+	default:
+		return fmt.Errorf("unsupported BIT value: %T", v)
+	}
+	return nil
+}
+
+// Value gives the big-endian bytes of the bits, which BIT columns take
+// whatever their width.
+func (b NullBits) Value() (driver.Value, error) {
+	if !b.Valid {
+		return nil, nil
+	}
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, b.Bits)
+	for len(buf) > 1 && buf[0] == 0 {
+		buf = buf[1:]
+	}
+	return buf, nil
+}
+
+func (b NullBits) String() string {
+	if !b.Valid {
+		return "NULL"
+	}
+	return "b'" + strconv.FormatUint(b.Bits, 2) + "'"
+}
+
+// Geometry is the scan type of spatial columns, holding the SRID and the WKB
+// of the value as MySQL stores them. It is saved as WKT, prefixed by the SRID
+// unless zero, like "SRID=4326;POINT(1 2)".
+type Geometry struct {
+	SRID uint32
+	// WKB is nil for NULL.
+	WKB []byte
+}
+
+func (g *Geometry) Scan(v interface{}) error {
+	switch vv := v.(type) {
+	case nil:
+		*g = Geometry{}
+	case []byte:
+		if len(vv) < 4 {
+			return fmt.Errorf("invalid geometry value: %x", vv)
+		}
+		*g = Geometry{
+			SRID: binary.LittleEndian.Uint32(vv),
+			WKB:  append([]byte{}, vv[4:]...),
+		}
+	default:
+		return fmt.Errorf("unsupported geometry value: %T", v)
+	}
+	return nil
+}
+
+// Value gives the internal format of MySQL, the SRID followed by the WKB.
+func (g Geometry) Value() (driver.Value, error) {
+	if g.WKB == nil {
+		return nil, nil
+	}
+	buf := make([]byte, 4, 4+len(g.WKB))
+	binary.LittleEndian.PutUint32(buf, g.SRID)
+	return append(buf, g.WKB...), nil
+}
+
+func (g Geometry) String() string {
+	if g.WKB == nil {
+		return "NULL"
+	}
+	wkt, err := wkbToWKT(g.WKB)
+	if err != nil {
+		wkt = "WKB " + hex.EncodeToString(g.WKB)
+	}
+	if g.SRID != 0 {
+		return fmt.Sprintf("SRID=%d;%s", g.SRID, wkt)
+	}
+	return wkt
+}
+
+func parseGeometry(s string) (Geometry, error) {
+	g := Geometry{}
+	if strings.HasPrefix(strings.ToUpper(s), "SRID=") {
+		i := strings.IndexByte(s, ';')
+		if i < 0 {
+			return g, fmt.Errorf("invalid geometry %q", s)
+		}
+		srid, err := strconv.ParseUint(s[len("SRID="):i], 10, 32)
+		if err != nil {
+			return g, fmt.Errorf("invalid geometry %q: %s", s, err)
+		}
+		g.SRID, s = uint32(srid), s[i+1:]
+	}
+
+	wkb, err := wktToWKB(s)
+	g.WKB = wkb
+	return g, err
+}
+
+// MaxInlineBlob is the size above which binary values are saved as their
+// length and SHA-256 rather than as their content. Checks compare them by
+// digest, but they can not be applied.
+var MaxInlineBlob = 1 << 16
+
+// BlobDigest stands for a binary value saved as its length and SHA-256.
+type BlobDigest struct {
+	Len    int
+	SHA256 string
+}
+
+func newBlobDigest(b []byte) BlobDigest {
+	sum := sha256.Sum256(b)
+	return BlobDigest{Len: len(b), SHA256: hex.EncodeToString(sum[:])}
+}
+
+func (d BlobDigest) Value() (driver.Value, error) {
+	return nil, fmt.Errorf("can not apply a blob of %d bytes saved as its digest, save it with a larger MaxInlineBlob", d.Len)
+}
+
+func (d BlobDigest) String() string {
+	return fmt.Sprintf("%d bytes sha256:%s", d.Len, d.SHA256)
+}
+
+func init() {
+	RegisterScanType(reflect.TypeOf(NullBits{}), Codec{
+		Encode: func(v interface{}) (interface{}, error) {
+			b := v.(NullBits)
+			if !b.Valid {
+				return nil, nil
+			}
+			return b.Bits, nil
+		},
+		Decode: func(v interface{}, _ reflect.Type) (interface{}, error) {
+			if v == nil {
+				return NullBits{}, nil
+			}
+			if s, ok := v.(string); ok && len(s) >= 3 && (s[0] == 'b' || s[0] == 'B') && s[1] == '\'' && s[len(s)-1] == '\'' {
+				n, err := strconv.ParseUint(s[2:len(s)-1], 2, 64)
+				return NullBits{Bits: n, Valid: err == nil}, err
+			}
+			n, err := toUint64(v)
+			return NullBits{Bits: n, Valid: err == nil}, err
+		},
+	})
+
+	RegisterScanType(reflect.TypeOf(Geometry{}), Codec{
+		Encode: func(v interface{}) (interface{}, error) {
+			g := v.(Geometry)
+			if g.WKB == nil {
+				return nil, nil
+			}
+			return g.String(), nil
+		},
+		Decode: func(v interface{}, _ reflect.Type) (interface{}, error) {
+			if v == nil {
+				return Geometry{}, nil
+			}
+			s, err := toString(v)
+			if err != nil {
+				return nil, err
+			}
+			return parseGeometry(s)
+		},
+		Compare: func(expect, actual interface{}) (string, bool) {
+			e, a := expect.(Geometry), actual.(Geometry)
+			if e.String() != a.String() {
+				return fmt.Sprintf("expect: %s, actual: %s", e, a), false
+			}
+			return "", true
+		},
+	})
+}
+
+// logicalType gives the type of a column, from its full type when known, as
+// MySQL sends ENUM and SET values as CHAR. Full types are only known for the
+// results of tables, the SET columns of queries compare as strings unless
+// given the SetEqual comparator.
+func logicalType(col *ColType) string {
+	full := strings.ToUpper(col.fullDatabaseType)
+	for _, typ := range []string{"ENUM", "SET", "BIT", "JSON"} {
+		if full == typ || strings.HasPrefix(full, typ+"(") {
+			return typ
+		}
+	}
+	return strings.ToUpper(col.databaseType)
+}
+
+// typeComparator compares JSON documents semantically, and SET values
+// ignoring the order of their members.
+func typeComparator(col *ColType) Comparator {
+	switch logicalType(col) {
+	case "JSON":
+		return jsonEqual
+	case "SET":
+		return setEqual
+	default:
+		return nil
+	}
+}
+
+func jsonEqual(expect, actual interface{}) (string, bool) {
+	es, eok := textValue(expect)
+	as, aok := textValue(actual)
+	if eok != aok {
+		return fmt.Sprintf("expect: %v, actual: %v", formatValue(expect), formatValue(actual)), false
+	}
+	if !eok || es == as {
+		return "", true
+	}
+
+	ev, err1 := decodeJSON(es)
+	av, err2 := decodeJSON(as)
+	if err1 != nil || err2 != nil || !jsonValueEqual(ev, av) {
+		return fmt.Sprintf("expect: %s, actual: %s", es, as), false
+	}
+	return "", true
+}
+
+func decodeJSON(s string) (interface{}, error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var v interface{}
+	err := dec.Decode(&v)
+	return v, err
+}
+
+// jsonValueEqual compares decoded JSON, numbers by value so that 1 and 1.0
+// are equal.
+func jsonValueEqual(a, b interface{}) bool {
+	switch av := a.(type) {
+	case json.Number:
+		bv, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		ar, ok1 := new(big.Rat).SetString(av.String())
+		br, ok2 := new(big.Rat).SetString(bv.String())
+		if !ok1 || !ok2 {
+			return av == bv
+		}
+		return ar.Cmp(br) == 0
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !jsonValueEqual(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for k, v := range av {
+			w, ok := bv[k]
+			if !ok || !jsonValueEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+func setEqual(expect, actual interface{}) (string, bool) {
+	es, eok := textValue(expect)
+	as, aok := textValue(actual)
+	if eok != aok {
+		return fmt.Sprintf("expect: %v, actual: %v", formatValue(expect), formatValue(actual)), false
+	}
+	if !eok || es == as {
+		return "", true
+	}
+
+	if strings.Join(setMembers(es), ",") != strings.Join(setMembers(as), ",") {
+		return fmt.Sprintf("expect: %q, actual: %q", es, as), false
+	}
+	return "", true
+}
+
+func setMembers(s string) []string {
+	if s == "" {
+		return nil
+	}
+	ms := strings.Split(s, ",")
+	sort.Strings(ms)
+	return ms
+}
+
+// textValue returns the text of a string or bytes value, false when NULL.
+func textValue(v interface{}) (string, bool) {
+	switch vv := v.(type) {
+	case sql.RawBytes:
+		return string(vv), vv != nil
+	case []byte:
+		return string(vv), vv != nil
+	default:
+		return decimalString(v)
+	}
+}
+
+// bytesEqual compares binary values, the expected one possibly saved as its
+// digest.
+func bytesEqual(expect, actual interface{}) bool {
+	a, _ := actual.(sql.RawBytes)
+	switch e := expect.(type) {
+	case BlobDigest:
+		return e == newBlobDigest(a)
+	case sql.RawBytes:
+		return bytes.Equal(e, a) && (e == nil) == (a == nil)
+	default:
+		return false
+	}
+}
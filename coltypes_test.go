@@ -0,0 +1,224 @@
+package dbtesting
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWKT(t *testing.T) {
+	for _, wkt := range []string{
+		"POINT(1 2)",
+		"POINT EMPTY",
+		"LINESTRING(0 0,1.5 -1,2 2)",
+		"POLYGON((0 0,4 0,4 4,0 0),(1 1,2 1,1 2,1 1))",
+		"MULTIPOINT((1 2),(3 4))",
+		"MULTILINESTRING((0 0,1 1),(2 2,3 3))",
+		"MULTIPOLYGON(((0 0,1 0,1 1,0 0)),((2 2,3 2,3 3,2 2)))",
+		"GEOMETRYCOLLECTION(POINT(1 2),LINESTRING(0 0,1 1))",
+		"GEOMETRYCOLLECTION EMPTY",
+	} {
+		wkb, err := wktToWKB(wkt)
+		if err != nil {
+			t.Errorf("%s: %s", wkt, err)
+			continue
+		}
+		s, err := wkbToWKT(wkb)
+		if err != nil || s != wkt {
+			t.Errorf("%s: round trip as %q, %v", wkt, s, err)
+		}
+	}
+
+	wkb, err := wktToWKB("multipoint (1 2, 3 4)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := wkbToWKT(wkb); s != "MULTIPOINT((1 2),(3 4))" {
+		t.Errorf("unexpected WKT %s", s)
+	}
+
+	for _, wkt := range []string{"POINT(1)", "CIRCLE(1 2)", "POINT(1 2) x", "LINESTRING(0 0,"} {
+		if _, err := wktToWKB(wkt); err == nil {
+			t.Errorf("%s: expect error", wkt)
+		}
+	}
+}
+
+func TestGeometry(t *testing.T) {
+	// SRID 4326 then the big-endian WKB of POINT(1 2), as MySQL sends it
+	raw, _ := hex.DecodeString("e6100000" + "00" + "00000001" + "3ff0000000000000" + "4000000000000000")
+	g := Geometry{}
+	err := g.Scan(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.String() != "SRID=4326;POINT(1 2)" {
+		t.Errorf("unexpected geometry %s", g)
+	}
+
+	v, err := encodeValue(g, reflect.TypeOf(g))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := decodeValue(v, reflect.TypeOf(g))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, same := comparatorOf(reflect.TypeOf(g))(g, d); !same {
+		t.Errorf("%s decoded as %s", g, d)
+	}
+	if dv, _ := d.(Geometry).Value(); !bytes.Equal(dv.([]byte)[:4], raw[:4]) {
+		t.Errorf("unexpected internal value %x", dv)
+	}
+}
+
+func TestNullBits(t *testing.T) {
+	b := NullBits{}
+	err := b.Scan([]byte{0x01, 0x05})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b != (NullBits{Bits: 261, Valid: true}) {
+		t.Errorf("unexpected bits %v", b)
+	}
+	if v, _ := b.Value(); !bytes.Equal(v.([]byte), []byte{0x01, 0x05}) {
+		t.Errorf("unexpected value %x", v)
+	}
+
+	for _, v := range []interface{}{"b'100000101'", uint64(261), "261"} {
+		d, err := decodeValue(v, reflect.TypeOf(b))
+		if err != nil || d != b {
+			t.Errorf("%v decoded as %v, %v", v, d, err)
+		}
+	}
+}
+
+func TestTypeComparator(t *testing.T) {
+	jsonCol := &ColType{name: "doc", databaseType: "JSON", scanType: reflect.TypeOf(sql.NullString{})}
+	setCol := &ColType{name: "tags", databaseType: "CHAR", fullDatabaseType: "set('a','b','c')", scanType: reflect.TypeOf("")}
+	charCol := &ColType{name: "name", databaseType: "CHAR", scanType: reflect.TypeOf("")}
+
+	ns := func(s string) sql.NullString { return sql.NullString{String: s, Valid: true} }
+	for _, v := range []struct {
+		col            *ColType
+		expect, actual interface{}
+		same           bool
+	}{
+		{jsonCol, ns(`{"a": 1, "b": [1, 2]}`), ns(`{"b":[1,2],"a":1.0}`), true},
+		{jsonCol, ns(`{"a": 1}`), ns(`{"a": "1"}`), false},
+		{jsonCol, ns(`[1, 2]`), ns(`[2, 1]`), false},
+		{jsonCol, sql.NullString{}, ns(`null`), false},
+		{setCol, "a,c", "c,a", true},
+		{setCol, "a", "a,b", false},
+		{setCol, "", "", true},
+		{charCol, "a,c", "c,a", false},
+	} {
+		_, same := compareCell(v.expect, v.actual, v.col, &Query{})
+		if same != v.same {
+			t.Errorf("%s: %v vs %v: expect same %t", v.col.name, v.expect, v.actual, v.same)
+		}
+	}
+}
+
+func TestBlobDigest(t *testing.T) {
+	defer func(n int) { MaxInlineBlob = n }(MaxInlineBlob)
+	MaxInlineBlob = 4
+
+	typ := reflect.TypeOf(sql.RawBytes{})
+	blob := sql.RawBytes("hello")
+	v, err := encodeValue(blob, typ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok || m["len"] != 5 || !strings.HasPrefix(m["sha256"].(string), "2cf24dba") {
+		t.Fatalf("unexpected encoding %v", v)
+	}
+
+	d, err := decodeValue(m, typ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, same := RawBytesEqual(d, blob); !same {
+		t.Errorf("digest %v should match", d)
+	}
+	if _, same := RawBytesEqual(d, sql.RawBytes("hellO")); same {
+		t.Errorf("digest %v should not match", d)
+	}
+	if _, err := d.(BlobDigest).Value(); err == nil {
+		t.Error("a digest should not be applied")
+	}
+
+	if _, same := RawBytesEqual(sql.RawBytes(nil), sql.RawBytes{}); same {
+		t.Error("NULL should differ from empty bytes")
+	}
+	r := &Result{
+		ResultType: ResultType{name: "t", colType: []*ColType{{name: "bin", scanType: typ}}},
+		data:       [][]interface{}{{blob}},
+	}
+	for _, enc := range []Encoding{JSON, YAML, CSV} {
+		data, meta, err := enc.Marshal(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r2, err := enc.Unmarshal(data, meta)
+		if err != nil {
+			t.Fatalf("%s: %s\n%s", enc.Ext(), err, data)
+		}
+		if d := DiffResult(r2, r); d != nil {
+			t.Errorf("%s: unexpected diff: %s\n%s", enc.Ext(), (&SnapshotDiff{Results: []*ResultDiff{d}}).String(), data)
+		}
+	}
+}
+
+func TestUpgradeScanTypes(t *testing.T) {
+	raw := reflect.TypeOf(sql.RawBytes{})
+	point, _ := hex.DecodeString("00000000" + "0101000000" + "000000000000f03f" + "0000000000000040")
+
+	// as recorded before BIT, GEOMETRY and JSON had their scan types
+	legacy := &Result{
+		ResultType: ResultType{name: "t", colType: []*ColType{
+			{name: "flags", databaseType: "BIT", scanType: raw},
+			{name: "pos", databaseType: "GEOMETRY", scanType: raw},
+			{name: "doc", databaseType: "JSON", nullable: true, scanType: raw},
+		}},
+		data: [][]interface{}{{sql.RawBytes{0x41}, sql.RawBytes(point), sql.RawBytes(`{"a": 1}`)}},
+	}
+	data, err := Marshal(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual := &Result{
+		ResultType: ResultType{name: "t", colType: []*ColType{
+			{name: "flags", databaseType: "BIT", scanType: reflect.TypeOf(NullBits{})},
+			{name: "pos", databaseType: "GEOMETRY", scanType: reflect.TypeOf(Geometry{})},
+			{name: "doc", databaseType: "JSON", nullable: true, scanType: reflect.TypeOf(sql.NullString{})},
+		}},
+		data: [][]interface{}{{
+			NullBits{Bits: 0x41, Valid: true},
+			Geometry{WKB: point[4:]},
+			sql.NullString{String: `{"a":1.0}`, Valid: true},
+		}},
+	}
+	if d := DiffResult(expect, actual); d != nil {
+		t.Errorf("unexpected diff: %s", (&SnapshotDiff{Results: []*ResultDiff{d}}).String())
+	}
+	if expect.colType[0].scanType != raw {
+		t.Error("the loaded result should be left as recorded")
+	}
+
+	// other changes of scan type still fail
+	actual.colType[0].databaseType, expect.colType[0].databaseType = "BLOB", "BLOB"
+	d := DiffResult(expect, actual)
+	if d == nil || !strings.Contains(d.Type, "record the snapshot again") {
+		t.Errorf("expect a type diff, got %v", d)
+	}
+}
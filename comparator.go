@@ -1,9 +1,15 @@
 package dbtesting
 
 import (
-	"bytes"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
+	dbtime "github.com/forsaken628/dbtesting/time"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -21,8 +27,8 @@ func TimeShouldAfter(expect, actual interface{}) (s string, b bool) {
 }
 
 func RawBytesEqual(expect, actual interface{}) (s string, b bool) {
-	if bytes.Compare(expect.(sql.RawBytes), actual.(sql.RawBytes)) != 0 {
-		return fmt.Sprintf("expect: %v, actual: %v", expect, actual), false
+	if !bytesEqual(expect, actual) {
+		return fmt.Sprintf("expect: %v, actual: %v", formatValue(expect), formatValue(actual)), false
 	}
 	return "", true
 }
@@ -33,3 +39,287 @@ func TimeEqual(expect, actual interface{}) (s string, b bool) {
 	}
 	return "", true
 }
+
+// Ignore makes the check accept any value of the column.
+func Ignore() Comparator {
+	return func(expect, actual interface{}) (string, bool) {
+		return "", true
+	}
+}
+
+// NotNull accepts any value of the column but NULL.
+func NotNull() Comparator {
+	return func(expect, actual interface{}) (string, bool) {
+		if _, ok := plainValue(actual); !ok {
+			return "expect not NULL, actual: NULL", false
+		}
+		return "", true
+	}
+}
+
+// IsNull only accepts NULL.
+func IsNull() Comparator {
+	return func(expect, actual interface{}) (string, bool) {
+		if _, ok := plainValue(actual); ok {
+			return fmt.Sprintf("expect NULL, actual: %s", formatValue(actual)), false
+		}
+		return "", true
+	}
+}
+
+// Regexp accepts the values whose text matches pattern. It panics if pattern
+// does not compile.
+func Regexp(pattern string) Comparator {
+	re := regexp.MustCompile(pattern)
+	return func(expect, actual interface{}) (string, bool) {
+		s, ok := textOf(actual)
+		if !ok || !re.MatchString(s) {
+			return fmt.Sprintf("actual %s does not match /%s/", formatValue(actual), pattern), false
+		}
+		return "", true
+	}
+}
+
+// WithinDuration accepts the times within d of the expected ones.
+func WithinDuration(d time.Duration) Comparator {
+	return func(expect, actual interface{}) (string, bool) {
+		et, eok := timeOf(expect)
+		at, aok := timeOf(actual)
+		if !eok || !aok {
+			return fmt.Sprintf("expect times, expect: %s, actual: %s", formatValue(expect), formatValue(actual)), false
+		}
+		if diff := absDuration(at.Sub(et)); diff > d {
+			return fmt.Sprintf("expect: %s, actual: %s, %s apart, more than %s", et, at, diff, d), false
+		}
+		return "", true
+	}
+}
+
+// TimeWithin accepts the times within window of the one given by relativeTo
+// at the time of the check, like the Now of the time package of dbtesting,
+// which is used when relativeTo is nil. The expected values are ignored.
+func TimeWithin(window time.Duration, relativeTo func() time.Time) Comparator {
+	if relativeTo == nil {
+		relativeTo = dbtime.Now
+	}
+	return func(expect, actual interface{}) (string, bool) {
+		at, ok := timeOf(actual)
+		if !ok {
+			return fmt.Sprintf("expect a time, actual: %s", formatValue(actual)), false
+		}
+		ref := relativeTo()
+		if diff := absDuration(at.Sub(ref)); diff > window {
+			return fmt.Sprintf("actual %s is %s away from %s, more than %s", at, diff, ref, window), false
+		}
+		return "", true
+	}
+}
+
+// FloatWithin accepts the numbers within eps of the expected ones, whether
+// floats, integers or decimal strings.
+func FloatWithin(eps float64) Comparator {
+	return func(expect, actual interface{}) (string, bool) {
+		ef, eok := numberOf(expect)
+		af, aok := numberOf(actual)
+		if !eok || !aok {
+			return fmt.Sprintf("expect numbers, expect: %s, actual: %s", formatValue(expect), formatValue(actual)), false
+		}
+		if diff := math.Abs(af - ef); !(diff <= eps) {
+			return fmt.Sprintf("expect: %v, actual: %v, %g apart, more than %g", ef, af, diff, eps), false
+		}
+		return "", true
+	}
+}
+
+// OneOf accepts the values equal to one of values, nil standing for NULL.
+// Numbers are compared by value and bytes as strings.
+func OneOf(values ...interface{}) Comparator {
+	return func(expect, actual interface{}) (string, bool) {
+		for _, v := range values {
+			if plainEqual(v, actual) {
+				return "", true
+			}
+		}
+
+		ss := make([]string, len(values))
+		for i, v := range values {
+			ss[i] = formatValue(v)
+		}
+		return fmt.Sprintf("actual %s is not one of %s", formatValue(actual), strings.Join(ss, ", ")), false
+	}
+}
+
+// JSONEqual compares JSON documents semantically, ignoring the order of keys
+// and the formatting of numbers.
+func JSONEqual() Comparator {
+	return jsonEqual
+}
+
+// SetEqual compares the values of SET columns, ignoring the order of their
+// members.
+func SetEqual() Comparator {
+	return setEqual
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// UUIDFormat accepts the UUIDs in canonical text form, and the 16 bytes
+// of BINARY(16) columns.
+func UUIDFormat() Comparator {
+	return func(expect, actual interface{}) (string, bool) {
+		v, ok := plainValue(actual)
+		if b, isBytes := v.([]byte); ok && isBytes && len(b) == 16 {
+			return "", true
+		}
+		s, ok := textOf(actual)
+		if !ok || !uuidPattern.MatchString(s) {
+			return fmt.Sprintf("actual %s is not a UUID", formatValue(actual)), false
+		}
+		return "", true
+	}
+}
+
+// All accepts the values accepted by every comparator, reporting the causes
+// of all the failing ones.
+func All(cs ...Comparator) Comparator {
+	return func(expect, actual interface{}) (string, bool) {
+		var causes []string
+		for _, c := range cs {
+			if cause, same := c(expect, actual); !same {
+				causes = append(causes, cause)
+			}
+		}
+		if len(causes) > 0 {
+			return fmt.Sprintf("%d of %d checks failed: %s", len(causes), len(cs), strings.Join(causes, "; ")), false
+		}
+		return "", true
+	}
+}
+
+// Any accepts the values accepted by at least one comparator, reporting the
+// causes of all of them otherwise.
+func Any(cs ...Comparator) Comparator {
+	return func(expect, actual interface{}) (string, bool) {
+		causes := make([]string, 0, len(cs))
+		for _, c := range cs {
+			cause, same := c(expect, actual)
+			if same {
+				return "", true
+			}
+			causes = append(causes, cause)
+		}
+		return fmt.Sprintf("none of %d checks passed: %s", len(cs), strings.Join(causes, "; ")), false
+	}
+}
+
+// Not accepts the values c rejects.
+func Not(c Comparator) Comparator {
+	return func(expect, actual interface{}) (string, bool) {
+		if _, same := c(expect, actual); same {
+			return fmt.Sprintf("expect the check to fail, but it passed with expect: %s, actual: %s", formatValue(expect), formatValue(actual)), false
+		}
+		return "", true
+	}
+}
+
+// plainValue unwraps the values of scan types, false when NULL.
+func plainValue(v interface{}) (interface{}, bool) {
+	if vv, ok := v.(driver.Valuer); ok {
+		dv, err := vv.Value()
+		if err != nil {
+			return v, true
+		}
+		v = dv
+	}
+
+	switch vv := v.(type) {
+	case nil:
+		return nil, false
+	case sql.RawBytes:
+		return []byte(vv), vv != nil
+	case []byte:
+		return vv, vv != nil
+	default:
+		return v, true
+	}
+}
+
+func textOf(v interface{}) (string, bool) {
+	p, ok := plainValue(v)
+	switch pv := p.(type) {
+	case string:
+		return pv, ok
+	case []byte:
+		return string(pv), ok
+	default:
+		return fmt.Sprint(p), ok
+	}
+}
+
+func timeOf(v interface{}) (time.Time, bool) {
+	p, ok := plainValue(v)
+	if !ok {
+		return time.Time{}, false
+	}
+	if t, isTime := p.(time.Time); isTime {
+		return t, true
+	}
+	s, _ := textOf(p)
+	t, err := toTime(s)
+	return t, err == nil
+}
+
+func numberOf(v interface{}) (float64, bool) {
+	p, ok := plainValue(v)
+	if !ok {
+		return 0, false
+	}
+	rv := reflect.ValueOf(p)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	s, _ := textOf(p)
+	f, err := strconv.ParseFloat(s, 64)
+	return f, err == nil
+}
+
+func plainEqual(a, b interface{}) bool {
+	ap, aok := plainValue(a)
+	bp, bok := plainValue(b)
+	if !aok || !bok {
+		return aok == bok
+	}
+
+	if at, ok := ap.(time.Time); ok {
+		bt, ok := timeOf(bp)
+		return ok && at.Equal(bt)
+	}
+	if af, ok := numberOf(ap); ok && !isText(ap) {
+		bf, ok := numberOf(bp)
+		return ok && af == bf
+	}
+	as, _ := textOf(ap)
+	bs, _ := textOf(bp)
+	return as == bs
+}
+
+func isText(v interface{}) bool {
+	switch v.(type) {
+	case string, []byte:
+		return true
+	default:
+		return false
+	}
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -0,0 +1,79 @@
+package dbtesting
+
+import (
+	"database/sql"
+	"github.com/go-sql-driver/mysql"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestComparators(t *testing.T) {
+	now := time.Date(2018, 12, 1, 9, 0, 0, 0, time.UTC)
+	ns := func(s string) sql.NullString { return sql.NullString{String: s, Valid: true} }
+	nt := func(t time.Time) mysql.NullTime { return mysql.NullTime{Time: t, Valid: true} }
+
+	for _, v := range []struct {
+		name           string
+		c              Comparator
+		expect, actual interface{}
+		same           bool
+		cause          string
+	}{
+		{"ignore", Ignore(), 1, 2, true, ""},
+		{"not null", NotNull(), nil, ns(""), true, ""},
+		{"not null", NotNull(), 1, sql.NullInt64{}, false, "expect not NULL"},
+		{"not null bytes", NotNull(), nil, sql.RawBytes(nil), false, "expect not NULL"},
+		{"is null", IsNull(), 1, sql.NullString{}, true, ""},
+		{"is null", IsNull(), nil, ns("x"), false, `expect NULL, actual: "x"`},
+		{"regexp", Regexp(`^ord-\d+$`), nil, ns("ord-12"), true, ""},
+		{"regexp", Regexp(`^ord-\d+$`), nil, sql.RawBytes("ord-x"), false, `does not match /^ord-\d+$/`},
+		{"regexp null", Regexp(`.*`), nil, sql.NullString{}, false, "actual NULL does not match"},
+		{"within duration", WithinDuration(time.Second), now, nt(now.Add(time.Second)), true, ""},
+		{"within duration", WithinDuration(time.Second), now, now.Add(-2 * time.Second), false, "2s apart, more than 1s"},
+		{"float within", FloatWithin(0.01), 1.0, "1.005", true, ""},
+		{"float within", FloatWithin(0.01), int64(1), sql.NullFloat64{Float64: 1.1, Valid: true}, false, "more than 0.01"},
+		{"one of", OneOf("a", "b"), nil, ns("b"), true, ""},
+		{"one of number", OneOf(1, 2), nil, int64(2), true, ""},
+		{"one of null", OneOf(nil), nil, sql.NullInt64{}, true, ""},
+		{"one of", OneOf("a", "b"), nil, ns("c"), false, `actual "c" is not one of "a", "b"`},
+		{"set", SetEqual(), "a,b", ns("b,a"), true, ""},
+		{"set", SetEqual(), "a,b", ns("a"), false, `expect: "a,b", actual: "a"`},
+		{"json", JSONEqual(), ns(`{"a":1,"b":2}`), sql.RawBytes(`{"b": 2, "a": 1.0}`), true, ""},
+		{"uuid", UUIDFormat(), nil, "123e4567-e89b-12d3-a456-426655440000", true, ""},
+		{"uuid binary", UUIDFormat(), nil, sql.RawBytes(make([]byte, 16)), true, ""},
+		{"uuid", UUIDFormat(), nil, "123e4567", false, "is not a UUID"},
+		{"time within", TimeWithin(time.Minute, func() time.Time { return now }), nil, nt(now.Add(-30 * time.Second)), true, ""},
+		{"time within", TimeWithin(time.Minute, func() time.Time { return now }), nil, now.Add(time.Hour), false, "1h0m0s away from"},
+		{"time within now", TimeWithin(time.Minute, nil), nil, time.Now(), true, ""},
+		{"all", All(NotNull(), Regexp(`^a`)), nil, "ab", true, ""},
+		{"all", All(NotNull(), Regexp(`^a`), Regexp(`b$`)), nil, "xy", false, "2 of 3 checks failed: actual \"xy\" does not match /^a/; actual \"xy\" does not match /b$/"},
+		{"any", Any(IsNull(), UUIDFormat()), nil, nil, true, ""},
+		{"any", Any(IsNull(), UUIDFormat()), nil, "x", false, "none of 2 checks passed: expect NULL"},
+		{"not", Not(IsNull()), nil, "x", true, ""},
+		{"not", Not(OneOf("x")), nil, "x", false, `expect the check to fail, but it passed with expect: NULL, actual: "x"`},
+	} {
+		cause, same := v.c(v.expect, v.actual)
+		if same != v.same || !strings.Contains(cause, v.cause) {
+			t.Errorf("%s: %v vs %v: got (%q, %t), expect (%q, %t)", v.name, v.expect, v.actual, cause, same, v.cause, v.same)
+		}
+	}
+}
+
+func TestComparatorInDiff(t *testing.T) {
+	r := newTestResult("t", []string{"id", "token"}, []interface{}{int64(1), "abc"})
+	actual := newTestResult("t", []string{"id", "token"}, []interface{}{int64(1), "xyz"})
+
+	q := NewQuery("t", "select 1")
+	q.RegisterComparator("token", All(NotNull(), Regexp(`^[a-z]{3}$`)))
+	r.query = q
+	if d := DiffResult(r, actual); d != nil {
+		t.Errorf("unexpected diff: %s", (&SnapshotDiff{Results: []*ResultDiff{d}}).String())
+	}
+
+	q.RegisterComparator("token", Not(Ignore()))
+	d := DiffResult(r, actual)
+	if d == nil || len(d.Changed) != 1 || !strings.Contains(d.Changed[0].Cells[0].Cause, "expect the check to fail") {
+		t.Errorf("expect token diff, got %v", d)
+	}
+}
@@ -1,17 +1,19 @@
 package dbtesting
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/forsaken628/bsql"
-	"github.com/go-sql-driver/mysql"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
-	"time"
+	"strconv"
+	"strings"
 )
 
 type ScanType struct {
@@ -23,40 +25,34 @@ func (t ScanType) MarshalJSON() ([]byte, error) {
 }
 
 func (t *ScanType) UnmarshalJSON(data []byte) error {
-	typs := []reflect.Type{
-		reflect.TypeOf(""),
-		reflect.TypeOf(sql.NullString{}),
-
-		reflect.TypeOf(int(0)),
-		reflect.TypeOf(int8(0)),
-		reflect.TypeOf(int16(0)),
-		reflect.TypeOf(int32(0)),
-		reflect.TypeOf(int64(0)),
-		reflect.TypeOf(uint(0)),
-		reflect.TypeOf(uint8(0)),
-		reflect.TypeOf(uint16(0)),
-		reflect.TypeOf(uint32(0)),
-		reflect.TypeOf(uint64(0)),
-		reflect.TypeOf(sql.NullInt64{}),
-
-		reflect.TypeOf(float32(0)),
-		reflect.TypeOf(float64(0)),
-		reflect.TypeOf(sql.NullFloat64{}),
+	var name string
+	err := json.Unmarshal(data, &name)
+	if err != nil {
+		return errors.New("unsupported value: " + string(data))
+	}
+	return t.setName(name)
+}
 
-		reflect.TypeOf(time.Time{}),
-		reflect.TypeOf(mysql.NullTime{}),
+func (t ScanType) MarshalYAML() (interface{}, error) {
+	return t.String(), nil
+}
 
-		reflect.TypeOf(sql.RawBytes{}),
+func (t *ScanType) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	err := unmarshal(&name)
+	if err != nil {
+		return err
 	}
+	return t.setName(name)
+}
 
-	for _, v := range typs {
-		if string(data) == `"`+v.String()+`"` {
-			t.Type = v
-			return nil
-		}
+func (t *ScanType) setName(name string) error {
+	typ, ok := scanTypeByName(name)
+	if !ok {
+		return errors.New("unsupported value: " + name + ", see RegisterScanType")
 	}
-
-	return errors.New("unsupported value: " + string(data))
+	t.Type = typ
+	return nil
 }
 
 type ColType struct {
@@ -74,6 +70,11 @@ type ColType struct {
 	precision    int64
 	scale        int64
 	scanType     reflect.Type
+
+	// fractional seconds and zone of time columns
+	hasDatetimePrecision bool
+	datetimePrecision    int64
+	zone                 string
 }
 
 func CompareColType(expect, actual *ColType) (string, bool) {
@@ -84,13 +85,43 @@ func CompareColType(expect, actual *ColType) (string, bool) {
 		return fmt.Sprintf("expect databaseType %s actual %s", expect.databaseType, actual.databaseType), false
 	}
 	if expect.scanType != actual.scanType {
-		return fmt.Sprintf("expect scanType %s actual %s", expect.scanType, actual.scanType), false
+		return fmt.Sprintf("expect scanType %s actual %s, record the snapshot again if it was saved by an older version", expect.scanType, actual.scanType), false
 	}
 	return "", true
 }
 
 func (c ColType) MarshalJSON() ([]byte, error) {
-	return json.Marshal(col4json{
+	return json.Marshal(c.col4json())
+}
+
+func (c *ColType) UnmarshalJSON(data []byte) error {
+	cc := col4json{}
+	err := json.Unmarshal(data, &cc)
+	if err != nil {
+		return err
+	}
+
+	c.setCol4json(cc)
+	return nil
+}
+
+func (c ColType) MarshalYAML() (interface{}, error) {
+	return c.col4json(), nil
+}
+
+func (c *ColType) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	cc := col4json{}
+	err := unmarshal(&cc)
+	if err != nil {
+		return err
+	}
+
+	c.setCol4json(cc)
+	return nil
+}
+
+func (c ColType) col4json() col4json {
+	cc := col4json{
 		FullDatabaseType:  c.fullDatabaseType,
 		Name:              c.name,
 		HasNullable:       c.hasNullable,
@@ -102,16 +133,15 @@ func (c ColType) MarshalJSON() ([]byte, error) {
 		Precision:         c.precision,
 		Scale:             c.scale,
 		ScanType:          ScanType{c.scanType},
-	})
-}
-
-func (c *ColType) UnmarshalJSON(data []byte) error {
-	cc := col4json{}
-	err := json.Unmarshal(data, &cc)
-	if err != nil {
-		return err
+		Zone:              c.zone,
+	}
+	if c.hasDatetimePrecision {
+		cc.DatetimePrecision = &c.datetimePrecision
 	}
+	return cc
+}
 
+func (c *ColType) setCol4json(cc col4json) {
 	*c = ColType{
 		fullDatabaseType:  cc.FullDatabaseType,
 		name:              cc.Name,
@@ -124,29 +154,43 @@ func (c *ColType) UnmarshalJSON(data []byte) error {
 		precision:         cc.Precision,
 		scale:             cc.Scale,
 		scanType:          cc.ScanType.Type,
+		zone:              cc.Zone,
 	}
-
-	return nil
+	if cc.DatetimePrecision != nil {
+		c.datetimePrecision, c.hasDatetimePrecision = *cc.DatetimePrecision, true
+	}
+	// older snapshots lack the semantics of their time columns
+	c.setTimeSemantics()
 }
 
 type col4json struct {
-	FullDatabaseType string
-
-	Name string
-
-	HasNullable       bool
-	HasLength         bool
-	HasPrecisionScale bool
-
-	Nullable     bool
-	Length       int64
-	DatabaseType string
-	Precision    int64
-	Scale        int64
-	ScanType     ScanType
+	FullDatabaseType string `yaml:"FullDatabaseType"`
+
+	Name string `yaml:"Name"`
+
+	HasNullable       bool `yaml:"HasNullable"`
+	HasLength         bool `yaml:"HasLength"`
+	HasPrecisionScale bool `yaml:"HasPrecisionScale"`
+
+	Nullable     bool     `yaml:"Nullable"`
+	Length       int64    `yaml:"Length"`
+	DatabaseType string   `yaml:"DatabaseType"`
+	Precision    int64    `yaml:"Precision"`
+	Scale        int64    `yaml:"Scale"`
+	ScanType     ScanType `yaml:"ScanType"`
+
+	// DatetimePrecision is the number of fractional digits of time columns,
+	// and Zone whether their values are instants ("UTC") or wall clocks
+	// without zone ("none").
+	DatetimePrecision *int64 `json:",omitempty" yaml:"DatetimePrecision,omitempty"`
+	Zone              string `json:",omitempty" yaml:"Zone,omitempty"`
 }
 
 func NewColType(cTyp *sql.ColumnType) *ColType {
+	return newColType(cTyp, MySQL)
+}
+
+func newColType(cTyp *sql.ColumnType, d Dialect) *ColType {
 	c := &ColType{}
 	c.name = cTyp.Name()
 	c.databaseType = cTyp.DatabaseTypeName()
@@ -154,22 +198,16 @@ func NewColType(cTyp *sql.ColumnType) *ColType {
 	c.precision, c.scale, c.hasPrecisionScale = cTyp.DecimalSize()
 	c.nullable, c.hasNullable = cTyp.Nullable()
 
-	switch c.databaseType {
-	case "CHAR", "VARCHAR", "TEXT", "DECIMAL":
-		if c.nullable {
-			c.scanType = reflect.TypeOf(sql.NullString{})
-		} else {
-			c.scanType = reflect.TypeOf("")
-		}
-	case "TIMESTAMP", "DATETIME":
-		if c.nullable {
-			c.scanType = reflect.TypeOf(mysql.NullTime{})
-		} else {
-			c.scanType = reflect.TypeOf(time.Time{})
-		}
-	default:
-		c.scanType = cTyp.ScanType()
+	typ, ok := scanTypeOfDatabaseType(c.databaseType)
+	if !ok {
+		typ = d.ScanType(cTyp, c.nullable)
 	}
+	if typ == nil || !registered(typ) {
+		// any column scans into bytes, which snapshots can hold
+		typ = reflect.TypeOf(sql.RawBytes{})
+	}
+	c.scanType = typ
+	c.setTimeSemantics()
 
 	return c
 }
@@ -201,11 +239,28 @@ type Row struct {
 
 type Result struct {
 	ResultType
-	query *Query
-	data  [][]interface{}
+	query   *Query
+	dialect Dialect
+	data    [][]interface{}
+
+	// recorded is the query read from the snapshot file, if any.
+	recorded *recordedQuery
+	// fixture holds the rows of a hand-written result until its column
+	// types are inferred, see resolve.
+	fixture []map[string]interface{}
+}
+
+type recordedQuery struct {
+	query       string
+	comparators map[string]string
 }
 
 func CompareResult(expect, actual *Result) (string, bool) {
+	expect, err := upgradeScanTypes(expect, actual)
+	if err != nil {
+		return err.Error(), false
+	}
+
 	diff, same := CompareResultType(&expect.ResultType, &actual.ResultType)
 	if !same {
 		return diff, false
@@ -226,34 +281,12 @@ func CompareResult(expect, actual *Result) (string, bool) {
 
 type Comparator func(expect, actual interface{}) (cause string, same bool)
 
-var typeComparators = map[reflect.Type]Comparator{
-	reflect.TypeOf(sql.RawBytes{}): RawBytesEqual,
-	reflect.TypeOf(time.Time{}):    TimeEqual,
-}
-
 func CompareRow(expect, actual []interface{}, colType []*ColType, nameComparators map[string]Comparator) (string, bool) {
+	q := &Query{comparators: nameComparators}
 	for j, val := range expect {
-
-		fn, ok := nameComparators[colType[j].name]
-		if ok {
-			cause, same := fn(val, actual[j])
-			if !same {
-				return fmt.Sprintf("check row fail, col: %s, %s", colType[j].name, cause), false
-			}
-			continue
-		}
-
-		fn, ok = typeComparators[colType[j].scanType]
-		if ok {
-			cause, same := fn(val, actual[j])
-			if !same {
-				return fmt.Sprintf("check row fail, col: %s, %s", colType[j].name, cause), false
-			}
-			continue
-		}
-
-		if val != actual[j] {
-			return fmt.Sprintf("check row fail, col: %s, expect: %v, actual: %v", colType[j].name, val, actual[j]), false
+		cause, same := compareCell(val, actual[j], colType[j], q)
+		if !same {
+			return fmt.Sprintf("check row fail, col: %s, %s", colType[j].name, cause), false
 		}
 	}
 	return "", true
@@ -270,38 +303,75 @@ func (r *Result) Index(i int) *Row {
 	}
 }
 
-func (r *Result) Apply(db *sql.DB) error {
+func (r *Result) Apply(db Executor) error {
 	if !r.isTable {
 		return errors.New("not a table")
 	}
 
-	_, err := db.Exec("truncate " + r.name)
+	err := resolveFixtures(db, dialectOf(r.dialect), []*Result{r})
 	if err != nil {
 		return err
 	}
 
-	cols := make([]string, len(r.colType))
-	for i, v := range r.colType {
-		cols[i] = v.name
+	err = r.clear(db, dialectOf(r.dialect), false)
+	if err != nil {
+		return err
 	}
 
+	return r.insert(db, dialectOf(r.dialect))
+}
+
+func (r *Result) clear(db Executor, dialect Dialect, referenced bool) error {
+	// truncate commits the transaction on MySQL, and fails on referenced tables
+	clear := dialect.Truncate(r.name)
+	if _, ok := db.(*sql.Tx); ok || referenced {
+		clear = dialect.Delete(r.name)
+	}
+
+	_, err := db.Exec(clear)
+	return err
+}
+
+// insert adds the rows in batches of at most 1000 consecutive rows setting
+// the same columns, leaving out the omitted ones.
+func (r *Result) insert(db Executor, dialect Dialect) error {
 	d := r.data
-	buf := make([][]interface{}, 1000)
 	for len(d) > 0 {
-		n := copy(buf, d)
+		mask := presentMask(d[0])
+		if len(mask) == 0 {
+			return fmt.Errorf("table %s: row without values", r.name)
+		}
+
+		n := 1
+		for n < len(d) && n < 1000 && sameMask(mask, d[n]) {
+			n++
+		}
+
+		cols := make([]string, len(mask))
+		for i, j := range mask {
+			cols[i] = dialect.Quote(r.colType[j].name)
+		}
+
+		rows := make([][]interface{}, n)
+		for i, row := range d[:n] {
+			rows[i] = make([]interface{}, len(mask))
+			for k, j := range mask {
+				rows[i][k] = insertValue(row[j], r.colType[j])
+			}
+		}
 		d = d[n:]
 
-		values, err := bsql.MakeValues(cols, buf[:n])
+		values, err := bsql.MakeValues(cols, rows)
 		if err != nil {
 			return err
 		}
 
 		q, a := bsql.Insert{
-			Table: bsql.Raw(r.name),
+			Table: bsql.Raw(dialect.Quote(r.name)),
 			Value: values,
 		}.Build()
 
-		_, err = db.Exec(q, a...)
+		_, err = db.Exec(rebind(dialect, q), a...)
 		if err != nil {
 			return err
 		}
@@ -310,7 +380,35 @@ func (r *Result) Apply(db *sql.DB) error {
 	return nil
 }
 
+// presentMask lists the indexes of the values of row which are not omitted.
+func presentMask(row []interface{}) []int {
+	mask := make([]int, 0, len(row))
+	for j, v := range row {
+		if _, ok := v.(omitted); !ok {
+			mask = append(mask, j)
+		}
+	}
+	return mask
+}
+
+func sameMask(mask []int, row []interface{}) bool {
+	m := presentMask(row)
+	if len(m) != len(mask) {
+		return false
+	}
+	for i := range m {
+		if m[i] != mask[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func Scan(r *sql.Rows) (*Result, error) {
+	return scan(r, MySQL)
+}
+
+func scan(r *sql.Rows, d Dialect) (*Result, error) {
 	columns, err := r.ColumnTypes()
 	if err != nil {
 		return nil, err
@@ -319,7 +417,7 @@ func Scan(r *sql.Rows) (*Result, error) {
 	cts := make([]*ColType, len(columns))
 
 	for i := range cts {
-		cts[i] = NewColType(columns[i])
+		cts[i] = newColType(columns[i], d)
 	}
 
 	data := make([][]interface{}, 0)
@@ -336,7 +434,13 @@ func Scan(r *sql.Rows) (*Result, error) {
 
 		row := make([]interface{}, len(columns))
 		for i := range rowScan {
-			row[i] = reflect.ValueOf(rowScan[i]).Elem().Interface()
+			v := reflect.ValueOf(rowScan[i]).Elem().Interface()
+			if b, ok := v.(sql.RawBytes); ok && b != nil {
+				// raw bytes point into the buffer of the driver, which the
+				// next row overwrites
+				v = append(make(sql.RawBytes, 0, len(b)), b...)
+			}
+			row[i] = normalizeCell(v, cts[i])
 		}
 
 		data = append(data, row)
@@ -346,28 +450,166 @@ func Scan(r *sql.Rows) (*Result, error) {
 		ResultType: ResultType{
 			colType: cts,
 		},
-		data: data,
+		dialect: d,
+		data:    data,
 	}, r.Err()
 }
 
+const FormatVersion = 2
+
+// header describes a result file, whatever the encoding of its rows.
+type header struct {
+	Version     int               `json:"version"`
+	Name        string            `json:"name"`
+	IsTable     bool              `json:"isTable"`
+	Query       *string           `json:"query,omitempty"`
+	Comparators map[string]string `json:"comparators,omitempty"`
+	Cols        []*ColType        `json:"cols"`
+}
+
+func newHeader(result *Result) header {
+	h := header{
+		Version: FormatVersion,
+		Name:    result.name,
+		IsTable: result.isTable,
+		Cols:    result.colType,
+	}
+	if result.query != nil {
+		h.Query = &result.query.query
+		h.Comparators = result.query.comparatorNames()
+	}
+	return h
+}
+
+// result returns the result described by the header, without rows.
+func (h header) result() *Result {
+	r := &Result{
+		ResultType: ResultType{
+			name:    h.Name,
+			isTable: h.IsTable,
+			colType: h.Cols,
+		},
+	}
+
+	if h.Query != nil {
+		r.recorded = &recordedQuery{
+			query:       *h.Query,
+			comparators: h.Comparators,
+		}
+	}
+
+	return r
+}
+
+type document struct {
+	header
+	Rows []orderedRow `json:"rows"`
+}
+
+// orderedRow is a row as a JSON object keeping the order of the columns.
+type orderedRow struct {
+	keys []string
+	vals []interface{}
+}
+
+func (r orderedRow) MarshalJSON() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
+	for i, k := range r.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		key, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+
+		val, err := json.Marshal(r.vals[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(val)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// rowKeys names the fields of the rows after the columns, suffixing
+// repeated names with #2, #3...
+func rowKeys(cols []*ColType) []string {
+	keys := make([]string, len(cols))
+	seen := make(map[string]int, len(cols))
+	for i, c := range cols {
+		seen[c.name]++
+		keys[i] = c.name
+		if n := seen[c.name]; n > 1 {
+			keys[i] = c.name + "#" + strconv.Itoa(n)
+		}
+	}
+	return keys
+}
+
+func encodeRows(result *Result) ([][]interface{}, error) {
+	rows := make([][]interface{}, len(result.data))
+	for i, row := range result.data {
+		rows[i] = make([]interface{}, len(row))
+		for j, v := range row {
+			ev, err := encodeCell(v, result.colType[j])
+			if err != nil {
+				return nil, fmt.Errorf("row %d col %s: %s", i, result.colType[j].name, err)
+			}
+			rows[i][j] = ev
+		}
+	}
+	return rows, nil
+}
+
+func decodeRows(cols []*ColType, rows [][]interface{}) ([][]interface{}, error) {
+	data := make([][]interface{}, len(rows))
+	for i, row := range rows {
+		if len(row) != len(cols) {
+			return nil, fmt.Errorf("row %d has %d values, expect %d", i, len(row), len(cols))
+		}
+
+		data[i] = make([]interface{}, len(row))
+		for j, v := range row {
+			dv, err := decodeCell(v, cols[j])
+			if err != nil {
+				return nil, fmt.Errorf("row %d col %s: %s", i, cols[j].name, err)
+			}
+			data[i][j] = dv
+		}
+	}
+	return data, nil
+}
+
 func Marshal(result *Result) ([]byte, error) {
-	// {cols:[],result:[[]]}
+	doc := document{
+		header: newHeader(result),
+		Rows:   make([]orderedRow, len(result.data)),
+	}
+
+	rows, err := encodeRows(result)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := rowKeys(result.colType)
+	for i, row := range rows {
+		doc.Rows[i] = orderedRow{keys: keys, vals: row}
+	}
 
-	//return json.Marshal()
-	return json.MarshalIndent(map[string]interface{}{
-		"name":    result.name,
-		"isTable": result.isTable,
-		"cols":    result.colType,
-		"data":    result.data,
-	}, "", "  ")
+	return json.MarshalIndent(doc, "", "  ")
 }
 
 func Unmarshal(data []byte) (*Result, error) {
 	var tmp struct {
-		Name    string
-		IsTable bool
-		Cols    []*ColType
-		Data    []json.RawMessage
+		header
+		Data []json.RawMessage
+		Rows []json.RawMessage
 	}
 
 	err := json.Unmarshal(data, &tmp)
@@ -375,86 +617,268 @@ func Unmarshal(data []byte) (*Result, error) {
 		return nil, err
 	}
 
-	rows := &Result{
-		ResultType: ResultType{
-			name:    tmp.Name,
-			isTable: tmp.IsTable,
-			colType: tmp.Cols,
-		},
-		data: make([][]interface{}, len(tmp.Data)),
+	rows := tmp.result()
+
+	version := tmp.Version
+	if version == 0 && tmp.Data == nil && tmp.Rows != nil {
+		// hand-written fixtures may omit the version
+		version = FormatVersion
+	}
+
+	switch version {
+	case 0:
+		rows.data, err = unmarshalV1(tmp.Cols, tmp.Data)
+	case FormatVersion:
+		if untyped(tmp.Cols) {
+			var objs []map[string]interface{}
+			objs, err = decodeObjects(tmp.Rows)
+			rows.setFixture(objs)
+		} else {
+			rows.data, err = unmarshalV2(tmp.Cols, tmp.Rows)
+		}
+	default:
+		err = fmt.Errorf("unsupported format version %d", tmp.Version)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	lsScan := make([]interface{}, len(tmp.Cols))
-	for i, v := range tmp.Cols {
+	return rows, nil
+}
+
+func unmarshalV1(cols []*ColType, raw []json.RawMessage) ([][]interface{}, error) {
+	data := make([][]interface{}, len(raw))
+
+	lsScan := make([]interface{}, len(cols))
+	for i, v := range cols {
 		lsScan[i] = reflect.New(v.scanType).Interface()
 	}
 
-	for i := range rows.data {
-		err = json.Unmarshal(tmp.Data[i], &lsScan)
+	for i := range data {
+		err := json.Unmarshal(raw[i], &lsScan)
 		if err != nil {
 			return nil, err
 		}
 
-		rows.data[i] = make([]interface{}, len(lsScan))
+		data[i] = make([]interface{}, len(lsScan))
 		for j := range lsScan {
-			rows.data[i][j] = reflect.ValueOf(lsScan[j]).Elem().Interface()
+			data[i][j] = reflect.ValueOf(lsScan[j]).Elem().Interface()
 		}
 	}
 
+	return data, nil
+}
+
+func unmarshalV2(cols []*ColType, raw []json.RawMessage) ([][]interface{}, error) {
+	objs, err := decodeObjects(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := alignRows(rowKeys(cols), objs)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeRows(cols, rows)
+}
+
+func decodeObjects(raw []json.RawMessage) ([]map[string]interface{}, error) {
+	objs := make([]map[string]interface{}, len(raw))
+	for i, r := range raw {
+		dec := json.NewDecoder(bytes.NewReader(r))
+		dec.UseNumber()
+
+		err := dec.Decode(&objs[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return objs, nil
+}
+
+// alignRows orders the values of the rows like keys.
+func alignRows(keys []string, objs []map[string]interface{}) ([][]interface{}, error) {
+	rows := make([][]interface{}, len(objs))
+	for i, obj := range objs {
+		if len(obj) != len(keys) {
+			return nil, fmt.Errorf("row %d has %d values, expect %d", i, len(obj), len(keys))
+		}
+
+		rows[i] = make([]interface{}, len(keys))
+		for j, k := range keys {
+			v, ok := obj[k]
+			if !ok {
+				return nil, fmt.Errorf("row %d: missing column %s", i, k)
+			}
+			rows[i][j] = v
+		}
+	}
 	return rows, nil
 }
 
+// Load reads a result file, decoded according to its extension. The column
+// types of the encodings unable to hold them are read from the sidecar file.
 func Load(path string) (*Result, error) {
+	meta := metaFile(path)
+	_, err := os.Stat(meta)
+	if os.IsNotExist(err) {
+		meta = ""
+	}
+
+	return load(path, meta)
+}
+
+func load(path, meta string) (*Result, error) {
 	data, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 
-	return Unmarshal(data)
+	var metaData []byte
+	if meta != "" {
+		metaData, err = ioutil.ReadFile(meta)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	r, err := encodingOf(path).Unmarshal(data, metaData)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if r.name == "" {
+		base := filepath.Base(path)
+		r.name = unescapeName(strings.TrimSuffix(base, filepath.Ext(base)))
+	}
+	return r, nil
+}
+
+func snapshotDir(testName, name string) string {
+	return filepath.Join(DefaultRoot, DefaultNaming(testName, name))
 }
 
 type Snapshot struct {
 	name     string
 	testName string
-	results  map[string]*Result
+	// dir is the directory of the snapshot, given by the TT, or else the
+	// default one.
+	dir      string
+	dialect  Dialect
+	encoding Encoding
+	results  []*Result
 }
 
-func (s *Snapshot) Save(overWrite bool) error {
-	path := filepath.Join("testdata/snapshot", s.testName, s.name)
-	_, err := os.Stat(path)
-	if !os.IsNotExist(err) {
-		if !overWrite {
-			return os.ErrExist
+func (s *Snapshot) path() string {
+	if s.dir != "" {
+		return s.dir
+	}
+	return snapshotDir(s.testName, s.name)
+}
+
+// layer adds the results of l, replacing the ones of the same name.
+func (s *Snapshot) layer(l *Snapshot) {
+	for _, r := range l.results {
+		replaced := false
+		for i, v := range s.results {
+			if v.name == r.name {
+				s.results[i] = r
+				replaced = true
+				break
+			}
 		}
-		err = os.RemoveAll(path)
-		if err != nil {
-			return err
+		if !replaced {
+			s.results = append(s.results, r)
+		}
+	}
+}
+
+func (s *Snapshot) result(name string) *Result {
+	for _, r := range s.results {
+		if r.name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// Save writes the snapshot, see Update. An existing snapshot is only
+// overwritten with overWrite.
+func (s *Snapshot) Save(overWrite bool) error {
+	_, err := os.Stat(s.path())
+	if !os.IsNotExist(err) && !overWrite {
+		return os.ErrExist
+	}
+
+	_, err = s.Update()
+	return err
+}
+
+// Apply replaces the content of the tables with the results. Tables are
+// cleared children first and filled parents first according to their foreign
+// keys, and foreign key checks are disabled when they reference each other,
+// which SQLite does not allow within a transaction.
+func (s *Snapshot) Apply(db Executor) (err error) {
+	dialect := dialectOf(s.dialect)
+
+	tables := make([]string, 0, len(s.results))
+	for _, r := range s.results {
+		if !r.isTable {
+			return errors.New("not a table: " + r.name)
 		}
+		tables = append(tables, r.name)
 	}
 
-	err = os.MkdirAll(path, 0755)
+	err = resolveFixtures(db, dialect, s.results)
 	if err != nil {
 		return err
 	}
 
-	for _, v := range s.results {
-		data, err := Marshal(v)
-		if err != nil {
+	order, referenced, err := applyOrder(db, dialect, tables)
+	if _, ok := err.(*ForeignKeyCycleError); ok {
+		disable, enable := dialect.ForeignKeyChecks()
+		if disable == "" {
 			return err
 		}
+		if _, ok := db.(*sql.Tx); ok && dialect == SQLite {
+			// pragma foreign_keys is a no-op within a transaction
+			return fmt.Errorf("%w: foreign key checks of SQLite can not be disabled within a transaction, apply the snapshot without WithTx", err)
+		}
+
+		if sqlDB, ok := db.(*sql.DB); ok {
+			conn, err := sqlDB.Conn(context.Background())
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			db = connExecutor{conn}
+		}
 
-		err = ioutil.WriteFile(filepath.Join(path, v.name), data, 0644)
+		_, err = db.Exec(disable)
 		if err != nil {
 			return err
 		}
+		defer func() {
+			_, e := db.Exec(enable)
+			if e != nil && err == nil {
+				err = e
+			}
+		}()
+
+		order, referenced = tables, nil
+	} else if err != nil {
+		return err
 	}
 
-	return nil
-}
+	for i := len(order) - 1; i >= 0; i-- {
+		err = s.result(order[i]).clear(db, dialect, referenced[order[i]])
+		if err != nil {
+			return err
+		}
+	}
 
-func (s *Snapshot) Apply(db *sql.DB) error {
-	for _, v := range s.results {
-		err := v.Apply(db)
+	for _, name := range order {
+		err = s.result(name).insert(db, dialect)
 		if err != nil {
 			return err
 		}
@@ -468,8 +892,12 @@ func CompareSnapshot(expect, actual *Snapshot) (string, bool) {
 		return "len(results)", false
 	}
 
-	for i, r := range expect.results {
-		diff, same := CompareResult(r, actual.results[i])
+	for _, r := range expect.results {
+		a := actual.result(r.name)
+		if a == nil {
+			return fmt.Sprintf("\ncheck snapshot fail, result name: %s\nmissing result", r.name), false
+		}
+		diff, same := CompareResult(r, a)
 		if !same {
 			return fmt.Sprintf("\ncheck snapshot fail, result name: %s\n%s", r.name, diff), false
 		}
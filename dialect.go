@@ -0,0 +1,344 @@
+package dbtesting
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Dialect hides the differences between databases: how columns are scanned,
+// how statements are written and how the schema is introspected.
+type Dialect interface {
+	// ScanType returns the type used to scan values of the column.
+	ScanType(c *sql.ColumnType, nullable bool) reflect.Type
+	// Quote quotes an identifier, which may be qualified as schema.table.
+	Quote(ident string) string
+	// Placeholder returns the bind parameter for the i-th argument, from 1.
+	Placeholder(i int) string
+	Truncate(table string) string
+	Delete(table string) string
+	Columns(db Executor, table string) ([]*ColumnDB, error)
+	Indexes(db Executor, table string) ([]*IndexDB, error)
+	ForeignKeys(db Executor, table string) ([]*ForeignKeyDB, error)
+	// ReferencingTables returns the other tables with foreign keys to table,
+	// whether in the snapshot or not.
+	ReferencingTables(db Executor, table string) ([]string, error)
+	Table(db Executor, table string) (*Table, error)
+	// ForeignKeyChecks returns the statements disabling and enabling foreign
+	// key checks for the session, or empty strings when not supported.
+	ForeignKeyChecks() (disable, enable string)
+}
+
+var (
+	MySQL  Dialect = mysqlDialect{}
+	SQLite Dialect = sqliteDialect{}
+)
+
+func dialectOf(d Dialect) Dialect {
+	if d == nil {
+		return MySQL
+	}
+	return d
+}
+
+// rebind replaces the ? placeholders produced by bsql with the ones of d.
+func rebind(d Dialect, q string) string {
+	if d.Placeholder(1) == "?" {
+		return q
+	}
+
+	b := strings.Builder{}
+	n := 0
+	for _, c := range q {
+		if c == '?' {
+			n++
+			b.WriteString(d.Placeholder(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func quoteWith(ident string, q string) string {
+	parts := strings.Split(ident, ".")
+	for i, p := range parts {
+		parts[i] = q + strings.Replace(p, q, q+q, -1) + q
+	}
+	return strings.Join(parts, ".")
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) ScanType(c *sql.ColumnType, nullable bool) reflect.Type {
+	switch c.DatabaseTypeName() {
+	case "CHAR", "VARCHAR", "TEXT", "DECIMAL", "JSON", "ENUM", "SET":
+		if nullable {
+			return reflect.TypeOf(sql.NullString{})
+		}
+		return reflect.TypeOf("")
+	case "TIMESTAMP", "DATETIME":
+		if nullable {
+			return reflect.TypeOf(mysql.NullTime{})
+		}
+		return reflect.TypeOf(time.Time{})
+	case "DATE":
+		return reflect.TypeOf(NullDate{})
+	case "TIME":
+		return reflect.TypeOf(NullDuration{})
+	case "YEAR":
+		return reflect.TypeOf(NullYear{})
+	case "BIT":
+		return reflect.TypeOf(NullBits{})
+	case "GEOMETRY":
+		return reflect.TypeOf(Geometry{})
+	default:
+		return c.ScanType()
+	}
+}
+
+func (mysqlDialect) Quote(ident string) string {
+	return quoteWith(ident, "`")
+}
+
+func (mysqlDialect) Placeholder(int) string {
+	return "?"
+}
+
+func (d mysqlDialect) Truncate(table string) string {
+	return "truncate " + d.Quote(table)
+}
+
+func (d mysqlDialect) Delete(table string) string {
+	return "delete from " + d.Quote(table)
+}
+
+func (mysqlDialect) ForeignKeyChecks() (string, string) {
+	return "set foreign_key_checks = 0", "set foreign_key_checks = 1"
+}
+
+func (mysqlDialect) Columns(db Executor, table string) ([]*ColumnDB, error) {
+	return FetchColumns(db, table)
+}
+
+func (mysqlDialect) Indexes(db Executor, table string) ([]*IndexDB, error) {
+	return FetchIndexes(db, table)
+}
+
+func (mysqlDialect) ForeignKeys(db Executor, table string) ([]*ForeignKeyDB, error) {
+	return FetchForeignKeys(db, table)
+}
+
+func (mysqlDialect) ReferencingTables(db Executor, table string) ([]string, error) {
+	return FetchReferencingTables(db, table)
+}
+
+func (mysqlDialect) Table(db Executor, table string) (*Table, error) {
+	return FetchTable(db, table)
+}
+
+type sqliteDialect struct{}
+
+// ScanType follows the type affinity rules of SQLite, every column is
+// considered nullable since the driver does not report it.
+func (sqliteDialect) ScanType(c *sql.ColumnType, nullable bool) reflect.Type {
+	typ := strings.ToUpper(c.DatabaseTypeName())
+	switch {
+	case strings.Contains(typ, "INT"):
+		return reflect.TypeOf(sql.NullInt64{})
+	case strings.Contains(typ, "CHAR"), strings.Contains(typ, "CLOB"), strings.Contains(typ, "TEXT"):
+		return reflect.TypeOf(sql.NullString{})
+	case strings.Contains(typ, "REAL"), strings.Contains(typ, "FLOA"), strings.Contains(typ, "DOUB"):
+		return reflect.TypeOf(sql.NullFloat64{})
+	case typ == "DATE":
+		return reflect.TypeOf(NullDate{})
+	case typ == "TIME":
+		return reflect.TypeOf(NullDuration{})
+	case strings.Contains(typ, "DATE"), strings.Contains(typ, "TIME"):
+		return reflect.TypeOf(sql.NullTime{})
+	case typ == "", strings.Contains(typ, "BLOB"):
+		return reflect.TypeOf(sql.RawBytes{})
+	default:
+		return reflect.TypeOf(sql.NullString{})
+	}
+}
+
+func (sqliteDialect) Quote(ident string) string {
+	return quoteWith(ident, `"`)
+}
+
+func (sqliteDialect) Placeholder(int) string {
+	return "?"
+}
+
+func (d sqliteDialect) Truncate(table string) string {
+	return d.Delete(table)
+}
+
+func (d sqliteDialect) Delete(table string) string {
+	return "delete from " + d.Quote(table)
+}
+
+func (sqliteDialect) ForeignKeyChecks() (string, string) {
+	return "pragma foreign_keys = off", "pragma foreign_keys = on"
+}
+
+func (d sqliteDialect) Columns(db Executor, table string) ([]*ColumnDB, error) {
+	rows, err := db.Query(fmt.Sprintf("pragma table_info(%s)", d.Quote(table)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cols []*ColumnDB
+	for rows.Next() {
+		var (
+			cid     int
+			notNull bool
+			pk      int
+			c       = &ColumnDB{TableName: table}
+		)
+
+		err = rows.Scan(&cid, &c.ColumnName, &c.ColumnType, &notNull, &c.ColumnDefault, &pk)
+		if err != nil {
+			return nil, err
+		}
+
+		c.OrdinalPosition = cid + 1
+		c.IsNullable = IsNullable(!notNull)
+		c.DataType = strings.ToLower(c.ColumnType)
+		if i := strings.IndexByte(c.DataType, '('); i >= 0 {
+			c.DataType = c.DataType[:i]
+		}
+		if pk > 0 {
+			c.ColumnKey = "PRI"
+		}
+		cols = append(cols, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(cols) == 0 {
+		return nil, fmt.Errorf("table not found: %s", table)
+	}
+	return cols, nil
+}
+
+func (d sqliteDialect) Indexes(db Executor, table string) ([]*IndexDB, error) {
+	type index struct {
+		name   string
+		unique bool
+	}
+
+	rows, err := db.Query(fmt.Sprintf("select name, \"unique\" from pragma_index_list(%s) order by name", d.quoteString(table)))
+	if err != nil {
+		return nil, err
+	}
+
+	var idx []index
+	for rows.Next() {
+		var v index
+		err = rows.Scan(&v.name, &v.unique)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		idx = append(idx, v)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var ls []*IndexDB
+	for _, v := range idx {
+		rows, err := db.Query(fmt.Sprintf("select seqno, name from pragma_index_info(%s) order by seqno", d.quoteString(v.name)))
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			i := &IndexDB{TableName: table, IndexName: v.name, IndexType: "BTREE"}
+			if !v.unique {
+				i.NonUnique = 1
+			}
+
+			err = rows.Scan(&i.SeqInIndex, &i.ColumnName)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			i.SeqInIndex++
+			ls = append(ls, i)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return ls, nil
+}
+
+func (d sqliteDialect) ForeignKeys(db Executor, table string) ([]*ForeignKeyDB, error) {
+	rows, err := db.Query(fmt.Sprintf("select seq, \"table\", \"from\", \"to\", on_update, on_delete, \"match\" from pragma_foreign_key_list(%s) order by id, seq", d.quoteString(table)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ls []*ForeignKeyDB
+	for rows.Next() {
+		var (
+			to sql.NullString
+			f  = &ForeignKeyDB{TableName: table}
+		)
+
+		// constraints are unnamed, their ids change when the table is rebuilt
+		err = rows.Scan(&f.OrdinalPosition, &f.ReferencedTableName, &f.ColumnName, &to, &f.UpdateRule, &f.DeleteRule, &f.MatchOption)
+		if err != nil {
+			return nil, err
+		}
+
+		f.OrdinalPosition++
+		f.ReferencedColumnName = to.String
+		ls = append(ls, f)
+	}
+
+	return ls, rows.Err()
+}
+
+func (d sqliteDialect) ReferencingTables(db Executor, table string) ([]string, error) {
+	return queryStrings(db, "select distinct m.name from sqlite_master m join pragma_foreign_key_list(m.name) f "+
+		"where m.type = 'table' and f.\"table\" = ? collate nocase and m.name <> ? collate nocase order by m.name", table, table)
+}
+
+func (d sqliteDialect) Table(db Executor, table string) (*Table, error) {
+	tab := &Table{T: TableDB{TableName: table, TableType: "BASE TABLE"}}
+
+	var err error
+	tab.C, err = d.Columns(db, table)
+	if err != nil {
+		return nil, err
+	}
+
+	tab.I, err = d.Indexes(db, table)
+	if err != nil {
+		return nil, err
+	}
+
+	tab.F, err = d.ForeignKeys(db, table)
+	if err != nil {
+		return nil, err
+	}
+
+	return tab, nil
+}
+
+func (sqliteDialect) quoteString(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
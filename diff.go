@@ -0,0 +1,366 @@
+package dbtesting
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+const DefaultMaxDiff = 20
+
+type CellDiff struct {
+	Column string
+	Expect interface{}
+	Actual interface{}
+	Cause  string
+}
+
+type RowDiff struct {
+	Row   int
+	Key   string
+	Cells []CellDiff
+}
+
+type ResultDiff struct {
+	Name    string
+	Columns []string
+	Type    string
+	Added   [][]interface{}
+	Removed [][]interface{}
+	Changed []RowDiff
+}
+
+func (d *ResultDiff) Len() int {
+	n := len(d.Added) + len(d.Removed)
+	for _, r := range d.Changed {
+		n += len(r.Cells)
+	}
+	if d.Type != "" {
+		n++
+	}
+	return n
+}
+
+type SnapshotDiff struct {
+	Missing []string
+	Extra   []string
+	Results []*ResultDiff
+}
+
+func (d *SnapshotDiff) Len() int {
+	n := len(d.Missing) + len(d.Extra)
+	for _, r := range d.Results {
+		n += r.Len()
+	}
+	return n
+}
+
+func compareCell(expect, actual interface{}, col *ColType, q *Query) (string, bool) {
+	if fn, ok := q.comparators[col.name]; ok {
+		return fn(expect, actual)
+	}
+
+	if fn := comparatorOf(col.scanType); fn != nil {
+		return fn(expect, actual)
+	}
+
+	if fn := numericComparator(col, q); fn != nil {
+		return fn(expect, actual)
+	}
+
+	if fn := typeComparator(col); fn != nil {
+		return fn(expect, actual)
+	}
+
+	if expect != actual {
+		return fmt.Sprintf("expect: %v, actual: %v", expect, actual), false
+	}
+	return "", true
+}
+
+func diffRow(expect, actual []interface{}, colType []*ColType, q *Query) []CellDiff {
+	var cells []CellDiff
+	for j, val := range expect {
+		cause, same := compareCell(val, actual[j], colType[j], q)
+		if !same {
+			cells = append(cells, CellDiff{
+				Column: colType[j].name,
+				Expect: val,
+				Actual: actual[j],
+				Cause:  cause,
+			})
+		}
+	}
+	return cells
+}
+
+// DiffResult compares the rows of expect with actual and returns nil when
+// both are the same. Rows are matched by position unless the query of expect
+// declares key columns or is unordered.
+func DiffResult(expect, actual *Result) *ResultDiff {
+	d := &ResultDiff{Name: expect.name}
+	for _, c := range expect.colType {
+		d.Columns = append(d.Columns, c.name)
+	}
+
+	expect, err := upgradeScanTypes(expect, actual)
+	if err != nil {
+		d.Type = err.Error()
+		return d
+	}
+
+	if cause, same := CompareResultType(&expect.ResultType, &actual.ResultType); !same {
+		d.Type = cause
+		return d
+	}
+
+	q := expect.query
+	if q == nil {
+		q = &Query{}
+	}
+
+	switch {
+	case len(q.keys) > 0:
+		diffByKey(d, expect, actual, q)
+	case q.unordered:
+		diffUnordered(d, expect, actual, q)
+	default:
+		diffByPosition(d, expect, actual, q)
+	}
+
+	if d.Len() == 0 {
+		return nil
+	}
+	return d
+}
+
+func diffByPosition(d *ResultDiff, expect, actual *Result, q *Query) {
+	for i, row := range expect.data {
+		if i >= len(actual.data) {
+			d.Removed = append(d.Removed, row)
+			continue
+		}
+
+		cells := diffRow(row, actual.data[i], expect.colType, q)
+		if len(cells) > 0 {
+			d.Changed = append(d.Changed, RowDiff{Row: i, Cells: cells})
+		}
+	}
+
+	if len(actual.data) > len(expect.data) {
+		d.Added = append(d.Added, actual.data[len(expect.data):]...)
+	}
+}
+
+func diffByKey(d *ResultDiff, expect, actual *Result, q *Query) {
+	idx := make([]int, len(q.keys))
+	for i, k := range q.keys {
+		idx[i] = -1
+		for j, c := range expect.colType {
+			if c.name == k {
+				idx[i] = j
+			}
+		}
+		if idx[i] < 0 {
+			d.Type = fmt.Sprintf("key column %s not found in %s", k, expect.name)
+			return
+		}
+	}
+
+	rowKey := func(row []interface{}) string {
+		ss := make([]string, len(idx))
+		for i, j := range idx {
+			ss[i] = q.keys[i] + "=" + formatValue(row[j])
+		}
+		return strings.Join(ss, " ")
+	}
+
+	pending := make(map[string][]int, len(actual.data))
+	for i, row := range actual.data {
+		k := rowKey(row)
+		pending[k] = append(pending[k], i)
+	}
+
+	matched := make([]bool, len(actual.data))
+	for i, row := range expect.data {
+		k := rowKey(row)
+		ls := pending[k]
+		if len(ls) == 0 {
+			d.Removed = append(d.Removed, row)
+			continue
+		}
+		pending[k] = ls[1:]
+		matched[ls[0]] = true
+
+		cells := diffRow(row, actual.data[ls[0]], expect.colType, q)
+		if len(cells) > 0 {
+			d.Changed = append(d.Changed, RowDiff{Row: i, Key: k, Cells: cells})
+		}
+	}
+
+	for i, row := range actual.data {
+		if !matched[i] {
+			d.Added = append(d.Added, row)
+		}
+	}
+}
+
+func diffUnordered(d *ResultDiff, expect, actual *Result, q *Query) {
+	matched := make([]bool, len(actual.data))
+	for _, row := range expect.data {
+		found := false
+		for j, ar := range actual.data {
+			if matched[j] {
+				continue
+			}
+			if len(diffRow(row, ar, expect.colType, q)) == 0 {
+				matched[j], found = true, true
+				break
+			}
+		}
+		if !found {
+			d.Removed = append(d.Removed, row)
+		}
+	}
+
+	for i, row := range actual.data {
+		if !matched[i] {
+			d.Added = append(d.Added, row)
+		}
+	}
+}
+
+// DiffSnapshot compares all results of two snapshots and returns nil when
+// they are the same.
+func DiffSnapshot(expect, actual *Snapshot) *SnapshotDiff {
+	d := &SnapshotDiff{}
+
+	for _, r := range expect.results {
+		a := actual.result(r.name)
+		if a == nil {
+			d.Missing = append(d.Missing, r.name)
+			continue
+		}
+
+		if rd := DiffResult(r, a); rd != nil {
+			d.Results = append(d.Results, rd)
+		}
+	}
+
+	for _, r := range actual.results {
+		if expect.result(r.name) == nil {
+			d.Extra = append(d.Extra, r.name)
+		}
+	}
+
+	if d.Len() == 0 {
+		return nil
+	}
+	return d
+}
+
+// Format renders the diff as a table, reporting at most max differences.
+// A max <= 0 means no limit.
+func (d *SnapshotDiff) Format(max int) string {
+	buf := &bytes.Buffer{}
+	n := 0
+	more := func() bool {
+		n++
+		return max <= 0 || n <= max
+	}
+
+	for _, name := range d.Missing {
+		if more() {
+			fmt.Fprintf(buf, "missing result: %s\n", name)
+		}
+	}
+	for _, name := range d.Extra {
+		if more() {
+			fmt.Fprintf(buf, "unexpected result: %s\n", name)
+		}
+	}
+
+	for _, r := range d.Results {
+		fmt.Fprintf(buf, "result %s: %d changed, %d added, %d removed\n", r.Name, len(r.Changed), len(r.Added), len(r.Removed))
+		if r.Type != "" {
+			if more() {
+				fmt.Fprintf(buf, "  %s\n", r.Type)
+			}
+			continue
+		}
+
+		w := tabwriter.NewWriter(buf, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "  \trow\tcolumn\texpect\tactual\t")
+		for _, row := range r.Changed {
+			at := strconv.Itoa(row.Row)
+			if row.Key != "" {
+				at = row.Key
+			}
+			for _, c := range row.Cells {
+				if more() {
+					fmt.Fprintf(w, "  ~\t%s\t%s\t%s\t%s\t\n", at, c.Column, formatValue(c.Expect), formatValue(c.Actual))
+				}
+			}
+		}
+		for _, row := range r.Removed {
+			if more() {
+				fmt.Fprintf(w, "  -\t\t*\t%s\t\t\n", formatRow(r.Columns, row))
+			}
+		}
+		for _, row := range r.Added {
+			if more() {
+				fmt.Fprintf(w, "  +\t\t*\t\t%s\t\n", formatRow(r.Columns, row))
+			}
+		}
+		w.Flush()
+	}
+
+	if max > 0 && n > max {
+		fmt.Fprintf(buf, "... and %d more differences\n", n-max)
+	}
+
+	return buf.String()
+}
+
+func (d *SnapshotDiff) String() string {
+	return d.Format(DefaultMaxDiff)
+}
+
+func formatRow(cols []string, row []interface{}) string {
+	ss := make([]string, len(row))
+	for i, v := range row {
+		if i < len(cols) {
+			ss[i] = cols[i] + "=" + formatValue(v)
+		} else {
+			ss[i] = formatValue(v)
+		}
+	}
+	return strings.Join(ss, " ")
+}
+
+func formatValue(v interface{}) string {
+	switch vv := v.(type) {
+	case nil:
+		return "NULL"
+	case sql.RawBytes:
+		return fmt.Sprintf("%q", string(vv))
+	case string:
+		return fmt.Sprintf("%q", vv)
+	case fmt.Stringer:
+		return vv.String()
+	case driver.Valuer:
+		dv, err := vv.Value()
+		if err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return formatValue(dv)
+	case []byte:
+		return fmt.Sprintf("%q", string(vv))
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
@@ -0,0 +1,96 @@
+package dbtesting
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTestResult(name string, cols []string, data ...[]interface{}) *Result {
+	cts := make([]*ColType, len(cols))
+	for i, c := range cols {
+		cts[i] = &ColType{name: c, databaseType: "INT", scanType: reflect.TypeOf(int64(0))}
+	}
+	return &Result{
+		ResultType: ResultType{name: name, isTable: true, colType: cts},
+		data:       data,
+	}
+}
+
+func TestDiffResult(t *testing.T) {
+	expect := newTestResult("t", []string{"id", "v"},
+		[]interface{}{int64(1), int64(10)},
+		[]interface{}{int64(2), int64(20)},
+		[]interface{}{int64(3), int64(30)},
+	)
+	actual := newTestResult("t", []string{"id", "v"},
+		[]interface{}{int64(1), int64(10)},
+		[]interface{}{int64(2), int64(21)},
+	)
+
+	d := DiffResult(expect, actual)
+	if d == nil {
+		t.Fatal("expect diff")
+	}
+	if len(d.Changed) != 1 || d.Changed[0].Row != 1 || d.Changed[0].Cells[0].Column != "v" {
+		t.Errorf("unexpected changed rows: %+v", d.Changed)
+	}
+	if len(d.Removed) != 1 || len(d.Added) != 0 {
+		t.Errorf("unexpected added/removed: %v %v", d.Added, d.Removed)
+	}
+
+	if d := DiffResult(expect, expect); d != nil {
+		t.Errorf("expect no diff, got %+v", d)
+	}
+}
+
+func TestSnapshotDiffFormat(t *testing.T) {
+	expect := &Snapshot{results: []*Result{
+		newTestResult("a", []string{"id"}, []interface{}{int64(1)}, []interface{}{int64(2)}, []interface{}{int64(3)}),
+		newTestResult("b", []string{"id"}),
+	}}
+	actual := &Snapshot{results: []*Result{
+		newTestResult("a", []string{"id"}, []interface{}{int64(4)}, []interface{}{int64(5)}, []interface{}{int64(6)}),
+		newTestResult("c", []string{"id"}),
+	}}
+
+	d := DiffSnapshot(expect, actual)
+	if d == nil || d.Len() != 5 {
+		t.Fatalf("unexpected diff: %+v", d)
+	}
+
+	out := d.Format(3)
+	if !strings.Contains(out, "missing result: b") || !strings.Contains(out, "... and 2 more differences") {
+		t.Errorf("unexpected format:\n%s", out)
+	}
+}
+
+func TestDiffResultMatching(t *testing.T) {
+	expect := newTestResult("t", []string{"id", "v"},
+		[]interface{}{int64(1), int64(10)},
+		[]interface{}{int64(2), int64(20)},
+		[]interface{}{int64(3), int64(30)},
+	)
+	actual := newTestResult("t", []string{"id", "v"},
+		[]interface{}{int64(4), int64(40)},
+		[]interface{}{int64(2), int64(21)},
+		[]interface{}{int64(1), int64(10)},
+	)
+
+	expect.query = &Query{keys: []string{"id"}}
+	d := DiffResult(expect, actual)
+	if d == nil || len(d.Changed) != 1 || d.Changed[0].Key != "id=2" || len(d.Added) != 1 || len(d.Removed) != 1 {
+		t.Errorf("unexpected key diff: %+v", d)
+	}
+
+	expect.query = &Query{unordered: true}
+	d = DiffResult(expect, actual)
+	if d == nil || len(d.Changed) != 0 || len(d.Added) != 2 || len(d.Removed) != 2 {
+		t.Errorf("unexpected unordered diff: %+v", d)
+	}
+
+	actual.data = [][]interface{}{expect.data[2], expect.data[0], expect.data[1]}
+	if d := DiffResult(expect, actual); d != nil {
+		t.Errorf("expect no unordered diff, got %+v", d)
+	}
+}
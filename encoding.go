@@ -0,0 +1,302 @@
+package dbtesting
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"path/filepath"
+	"strings"
+)
+
+// Encoding is a file format of the results of a snapshot.
+type Encoding interface {
+	// Ext is the extension of the result files, like ".json".
+	Ext() string
+	// Marshal encodes the result. Encodings unable to hold the column types
+	// return them as meta, which is saved in a sidecar file.
+	Marshal(result *Result) (data, meta []byte, err error)
+	Unmarshal(data, meta []byte) (*Result, error)
+}
+
+var (
+	JSON Encoding = jsonEncoding{}
+	YAML Encoding = yamlEncoding{}
+	// CSV writes a header row of the column names, NULL as \N and binary
+	// values as \x followed by their hex, or \sha256:<len>:<digest> beyond
+	// MaxInlineBlob. Cells starting with a backslash are escaped by another
+	// one.
+	CSV Encoding = csvEncoding{}
+)
+
+// encodingOf selects the encoding of a result file by its extension, files
+// without a known extension are JSON.
+func encodingOf(path string) Encoding {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return YAML
+	case ".csv":
+		return CSV
+	default:
+		return JSON
+	}
+}
+
+// metaFile is the sidecar file of the result file path.
+func metaFile(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".meta.json"
+}
+
+type jsonEncoding struct{}
+
+func (jsonEncoding) Ext() string {
+	return ".json"
+}
+
+func (jsonEncoding) Marshal(result *Result) ([]byte, []byte, error) {
+	data, err := Marshal(result)
+	return data, nil, err
+}
+
+func (jsonEncoding) Unmarshal(data, _ []byte) (*Result, error) {
+	return Unmarshal(data)
+}
+
+type yamlEncoding struct{}
+
+type yamlDocument struct {
+	Version     int               `yaml:"version"`
+	Name        string            `yaml:"name"`
+	IsTable     bool              `yaml:"isTable"`
+	Query       *string           `yaml:"query,omitempty"`
+	Comparators map[string]string `yaml:"comparators,omitempty"`
+	Cols        []*ColType        `yaml:"cols"`
+	Rows        []yaml.MapSlice   `yaml:"rows"`
+}
+
+func (yamlEncoding) Ext() string {
+	return ".yaml"
+}
+
+func (yamlEncoding) Marshal(result *Result) ([]byte, []byte, error) {
+	h := newHeader(result)
+	doc := yamlDocument{
+		Version:     h.Version,
+		Name:        h.Name,
+		IsTable:     h.IsTable,
+		Query:       h.Query,
+		Comparators: h.Comparators,
+		Cols:        h.Cols,
+		Rows:        make([]yaml.MapSlice, len(result.data)),
+	}
+
+	rows, err := encodeRows(result)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys := rowKeys(result.colType)
+	for i, row := range rows {
+		doc.Rows[i] = make(yaml.MapSlice, len(row))
+		for j, v := range row {
+			doc.Rows[i][j] = yaml.MapItem{Key: keys[j], Value: v}
+		}
+	}
+
+	data, err := yaml.Marshal(doc)
+	return data, nil, err
+}
+
+func (yamlEncoding) Unmarshal(data, _ []byte) (*Result, error) {
+	doc := yamlDocument{}
+	err := yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, err
+	}
+	if doc.Version != FormatVersion && !(doc.Version == 0 && untyped(doc.Cols)) {
+		return nil, fmt.Errorf("unsupported format version %d", doc.Version)
+	}
+
+	result := header{
+		Version:     doc.Version,
+		Name:        doc.Name,
+		IsTable:     doc.IsTable,
+		Query:       doc.Query,
+		Comparators: doc.Comparators,
+		Cols:        doc.Cols,
+	}.result()
+
+	objs := make([]map[string]interface{}, len(doc.Rows))
+	for i, r := range doc.Rows {
+		objs[i] = make(map[string]interface{}, len(r))
+		for _, item := range r {
+			objs[i][fmt.Sprint(item.Key)] = yamlValue(item.Value)
+		}
+	}
+
+	if untyped(doc.Cols) {
+		result.setFixture(objs)
+		return result, nil
+	}
+
+	rows, err := alignRows(rowKeys(doc.Cols), objs)
+	if err != nil {
+		return nil, err
+	}
+
+	result.data, err = decodeRows(doc.Cols, rows)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// yamlValue turns the mappings decoded as yaml.MapSlice into maps.
+func yamlValue(v interface{}) interface{} {
+	ms, ok := v.(yaml.MapSlice)
+	if !ok {
+		return v
+	}
+
+	m := make(map[string]interface{}, len(ms))
+	for _, item := range ms {
+		m[fmt.Sprint(item.Key)] = yamlValue(item.Value)
+	}
+	return m
+}
+
+type csvEncoding struct{}
+
+const csvNull = `\N`
+
+func (csvEncoding) Ext() string {
+	return ".csv"
+}
+
+func (csvEncoding) Marshal(result *Result) ([]byte, []byte, error) {
+	meta, err := json.MarshalIndent(newHeader(result), "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := encodeRows(result)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	err = w.Write(rowKeys(result.colType))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	record := make([]string, len(result.colType))
+	for _, row := range rows {
+		for j, v := range row {
+			record[j] = csvCell(v)
+		}
+		err = w.Write(record)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	w.Flush()
+	return buf.Bytes(), meta, w.Error()
+}
+
+// Unmarshal of a CSV file without sidecar gives a fixture of the table
+// named by the file, see Load.
+func (csvEncoding) Unmarshal(data, meta []byte) (*Result, error) {
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("missing header row")
+	}
+
+	if meta == nil {
+		objs := make([]map[string]interface{}, len(records)-1)
+		for i, record := range records[1:] {
+			objs[i] = make(map[string]interface{}, len(record))
+			for j, cell := range record {
+				objs[i][records[0][j]] = parseCSVCell(cell)
+			}
+		}
+
+		result := &Result{}
+		result.setFixture(objs)
+		return result, nil
+	}
+
+	h := header{}
+	err = json.Unmarshal(meta, &h)
+	if err != nil {
+		return nil, err
+	}
+	if h.Version != FormatVersion {
+		return nil, fmt.Errorf("unsupported format version %d", h.Version)
+	}
+
+	keys := rowKeys(h.Cols)
+	if strings.Join(records[0], ",") != strings.Join(keys, ",") {
+		return nil, fmt.Errorf("header row %v does not match columns %v", records[0], keys)
+	}
+
+	rows := make([][]interface{}, len(records)-1)
+	for i, record := range records[1:] {
+		rows[i] = make([]interface{}, len(record))
+		for j, cell := range record {
+			rows[i][j] = parseCSVCell(cell)
+		}
+	}
+
+	result := h.result()
+	result.data, err = decodeRows(h.Cols, rows)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func csvCell(v interface{}) string {
+	switch vv := v.(type) {
+	case nil:
+		return csvNull
+	case string:
+		if strings.HasPrefix(vv, `\`) {
+			return `\` + vv
+		}
+		return vv
+	case map[string]interface{}:
+		if sum, ok := vv["sha256"]; ok {
+			return fmt.Sprintf(`\sha256:%v:%v`, vv["len"], sum)
+		}
+		return `\x` + fmt.Sprint(vv["hex"])
+	default:
+		return fmt.Sprint(vv)
+	}
+}
+
+func parseCSVCell(cell string) interface{} {
+	switch {
+	case cell == csvNull:
+		return nil
+	case strings.HasPrefix(cell, `\\`):
+		return cell[1:]
+	case strings.HasPrefix(cell, `\x`):
+		return map[string]interface{}{"hex": cell[2:]}
+	case strings.HasPrefix(cell, `\sha256:`):
+		parts := strings.SplitN(cell[len(`\sha256:`):], ":", 2)
+		if len(parts) == 2 {
+			return map[string]interface{}{"len": parts[0], "sha256": parts[1]}
+		}
+		return cell
+	default:
+		return cell
+	}
+}
@@ -0,0 +1,92 @@
+package dbtesting
+
+import (
+	"database/sql"
+	"github.com/go-sql-driver/mysql"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEncodings(t *testing.T) {
+	ts := time.Date(2018, 12, 1, 9, 0, 0, 500, time.UTC)
+	types := []interface{}{int32(0), sql.NullString{}, sql.NullString{}, sql.RawBytes{}, mysql.NullTime{}, sql.NullFloat64{}, uint64(0)}
+	names := []string{"id", "name", "note", "bin", "at", "score", "id"}
+
+	cols := make([]*ColType, len(types))
+	for i, v := range types {
+		cols[i] = &ColType{name: names[i], scanType: reflect.TypeOf(v)}
+	}
+
+	r := &Result{
+		ResultType: ResultType{name: "t", isTable: true, colType: cols},
+		data: [][]interface{}{
+			{int32(1), sql.NullString{String: "a,\"b\"\n", Valid: true}, sql.NullString{String: `\N`, Valid: true}, sql.RawBytes{0, 1, 255}, mysql.NullTime{Time: ts, Valid: true}, sql.NullFloat64{Float64: 1.5, Valid: true}, uint64(1 << 63)},
+			{int32(2), sql.NullString{}, sql.NullString{String: "123", Valid: true}, sql.RawBytes(nil), mysql.NullTime{}, sql.NullFloat64{Float64: 2, Valid: true}, uint64(0)},
+			{int32(3), sql.NullString{String: "2018-12-01", Valid: true}, sql.NullString{String: "", Valid: true}, sql.RawBytes("x"), mysql.NullTime{}, sql.NullFloat64{}, uint64(1)},
+		},
+	}
+
+	for _, enc := range []Encoding{JSON, YAML, CSV} {
+		t.Run(enc.Ext(), func(t *testing.T) {
+			data, meta, err := enc.Marshal(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (meta != nil) != (enc == CSV) {
+				t.Errorf("unexpected meta: %s", meta)
+			}
+
+			r2, err := enc.Unmarshal(data, meta)
+			if err != nil {
+				t.Fatalf("%s\n%s", err, data)
+			}
+			if d := DiffResult(r, r2); d != nil {
+				t.Errorf("unexpected diff: %s\n%s", (&SnapshotDiff{Results: []*ResultDiff{d}}).String(), data)
+			}
+		})
+	}
+}
+
+func TestSnapshotEncoding(t *testing.T) {
+	s := &Snapshot{
+		name:     "csv",
+		testName: t.Name(),
+		encoding: CSV,
+		results: []*Result{
+			newTestResult("a", []string{"id"}, []interface{}{int64(1)}),
+		},
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(snapshotDir(s.testName, ""))
+	})
+
+	err := s.Save(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := snapshotDir(s.testName, s.name)
+	m, err := LoadManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Results[0].File != "a.csv" || m.Results[0].Meta != "a.meta.json" {
+		t.Errorf("unexpected manifest: %+v", m.Results[0])
+	}
+
+	ls, err := loadResults(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := DiffResult(s.results[0], ls[0]); d != nil {
+		t.Errorf("unexpected diff: %s", (&SnapshotDiff{Results: []*ResultDiff{d}}).String())
+	}
+
+	r, err := Load(filepath.Join(path, "a.csv"))
+	if err != nil || r.Len() != 1 {
+		t.Errorf("csv should load with its sidecar: %v", err)
+	}
+}
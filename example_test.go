@@ -72,3 +72,34 @@ func TestAA2(t *testing.T) {
 
 	})
 }
+
+func TestParallel(t *testing.T) {
+	db, err := sql.Open("mysql", "root@tcp(127.0.0.1)/?charset=utf8mb4&parseTime=true&loc=Local")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	p := &dbtesting.Provisioner{
+		DB:       db,
+		DSN:      "root@tcp(127.0.0.1)/?charset=utf8mb4&parseTime=true&loc=Local",
+		Template: "test",
+	}
+
+	for _, name := range []string{"a", "b"} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			tt := p.NewTT(t)
+
+			if tt.Initial(&dbtesting.InitialArgs{
+				Active: dbtesting.ActiveApply,
+				Tables: []string{"tab1", "tab2"},
+			}) {
+				return
+			}
+		})
+	}
+}
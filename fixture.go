@@ -0,0 +1,291 @@
+package dbtesting
+
+import (
+	"crypto/rand"
+	"fmt"
+	dbtime "github.com/forsaken628/dbtesting/time"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LabelKey names a row of a fixture, so that other rows can refer to it with
+// {{ref table.label.column}}. It is not a column.
+const LabelKey = "_label"
+
+// omitted is the value of the columns left out of a fixture row, which take
+// their default on insert.
+type omitted struct{}
+
+var templateReg = regexp.MustCompile(`^\{\{\s*(\w+)\s*([^}]*?)\s*\}\}$`)
+
+// untyped reports whether the columns lack the types needed to decode the
+// rows, as in hand-written fixtures listing only column names and values.
+func untyped(cols []*ColType) bool {
+	if len(cols) == 0 {
+		return true
+	}
+	for _, c := range cols {
+		if c.scanType == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// setFixture keeps the rows of a hand-written table result, decoded by
+// resolve once the table is at hand.
+func (r *Result) setFixture(objs []map[string]interface{}) {
+	r.isTable = true
+	r.colType = nil
+	r.fixture = objs
+}
+
+// resolveFixtures expands the templates of the fixtures among results, then
+// decodes them with the column types of their tables.
+//
+// String values may be one of the templates:
+//
+//	{{now}}                     the current time of dbtesting/time
+//	{{seq}}                     1, 2, 3... counting per table and column
+//	{{uuid}}                    a random UUID
+//	{{ref table.label.column}}  the value of column in the row labeled by
+//	                            LabelKey, column defaults to id
+//
+// Templates, labels and omitted columns are only read in fixtures whose
+// columns lack a ScanType, or CSV files without sidecar. Typed results, as
+// recorded, must have every column and their strings are inserted as is.
+func resolveFixtures(db Executor, d Dialect, results []*Result) error {
+	env := &fixtureEnv{
+		now:      dbtime.Now(),
+		seqs:     make(map[string]int64),
+		fixtures: make(map[string]*Result),
+		labels:   make(map[string]map[string]map[string]interface{}),
+		state:    make(map[string]int),
+	}
+	for _, r := range results {
+		if r.fixture != nil {
+			env.fixtures[r.name] = r
+		}
+	}
+
+	for _, r := range results {
+		if r.fixture == nil {
+			continue
+		}
+
+		err := env.expand(r.name)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, r := range results {
+		err := r.resolve(db, d)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+const (
+	expanding = iota + 1
+	expanded
+)
+
+type fixtureEnv struct {
+	now      time.Time
+	seqs     map[string]int64
+	fixtures map[string]*Result
+	labels   map[string]map[string]map[string]interface{}
+	state    map[string]int
+}
+
+// expand replaces the templates of a fixture, expanding first the fixtures
+// it refers to.
+func (e *fixtureEnv) expand(table string) error {
+	switch e.state[table] {
+	case expanded:
+		return nil
+	case expanding:
+		return fmt.Errorf("fixture %s: references form a cycle", table)
+	}
+	e.state[table] = expanding
+
+	r := e.fixtures[table]
+	labels := make(map[string]map[string]interface{})
+	e.labels[table] = labels
+	for i, obj := range r.fixture {
+		l, ok := obj[LabelKey]
+		if !ok {
+			continue
+		}
+		delete(obj, LabelKey)
+
+		label := fmt.Sprint(l)
+		if _, ok := labels[label]; ok {
+			return fmt.Errorf("fixture %s: row %d: duplicate label %s", table, i, label)
+		}
+		labels[label] = obj
+	}
+
+	for i, obj := range r.fixture {
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			s, ok := obj[k].(string)
+			if !ok {
+				continue
+			}
+
+			v, err := e.template(table, k, s)
+			if err != nil {
+				return fmt.Errorf("fixture %s: row %d col %s: %s", table, i, k, err)
+			}
+			obj[k] = v
+		}
+	}
+
+	e.state[table] = expanded
+	return nil
+}
+
+func (e *fixtureEnv) template(table, col, s string) (interface{}, error) {
+	m := templateReg.FindStringSubmatch(s)
+	if m == nil {
+		return s, nil
+	}
+
+	switch m[1] {
+	case "now":
+		return e.now.Format(time.RFC3339Nano), nil
+	case "seq":
+		e.seqs[table+"."+col]++
+		return e.seqs[table+"."+col], nil
+	case "uuid":
+		return newUUID()
+	case "ref":
+		return e.ref(table, m[2])
+	default:
+		return nil, fmt.Errorf("unknown template %s", s)
+	}
+}
+
+func (e *fixtureEnv) ref(from, arg string) (interface{}, error) {
+	parts := strings.Split(arg, ".")
+	if len(parts) == 2 {
+		parts = append(parts, "id")
+	}
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid ref %q, expect table.label.column", arg)
+	}
+	table, label, col := parts[0], parts[1], parts[2]
+
+	if _, ok := e.fixtures[table]; !ok {
+		return nil, fmt.Errorf("ref %s: no fixture of table %s", arg, table)
+	}
+	if table != from {
+		err := e.expand(table)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	obj, ok := e.labels[table][label]
+	if !ok {
+		return nil, fmt.Errorf("ref %s: no row labeled %s", arg, label)
+	}
+
+	v, ok := obj[col]
+	if !ok {
+		return nil, fmt.Errorf("ref %s: column %s is omitted, give it a value or {{seq}}", arg, col)
+	}
+	if s, ok := v.(string); ok && templateReg.MatchString(s) {
+		return nil, fmt.Errorf("ref %s: the row is not expanded yet, refer to earlier rows of the same table", arg)
+	}
+
+	return v, nil
+}
+
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// resolve decodes the rows of a fixture with the column types of the table,
+// ordered like the table. Columns left out of a row are omitted.
+func (r *Result) resolve(db Executor, d Dialect) error {
+	if r.fixture == nil {
+		return nil
+	}
+
+	rows, err := db.Query("select * from " + d.Quote(r.name) + " where 1 = 0")
+	if err != nil {
+		return err
+	}
+	cts, err := rows.ColumnTypes()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	used := make(map[string]bool)
+	for _, obj := range r.fixture {
+		for k := range obj {
+			used[k] = true
+		}
+	}
+
+	cols := make([]*ColType, 0, len(used))
+	for _, ct := range cts {
+		if used[ct.Name()] {
+			cols = append(cols, newColType(ct, d))
+			delete(used, ct.Name())
+		}
+	}
+
+	if len(used) > 0 {
+		unknown := make([]string, 0, len(used))
+		for k := range used {
+			unknown = append(unknown, k)
+		}
+		sort.Strings(unknown)
+		return fmt.Errorf("fixture %s: unknown columns %s", r.name, strings.Join(unknown, ", "))
+	}
+
+	data := make([][]interface{}, len(r.fixture))
+	for i, obj := range r.fixture {
+		data[i] = make([]interface{}, len(cols))
+		for j, c := range cols {
+			v, ok := obj[c.name]
+			if !ok {
+				data[i][j] = omitted{}
+				continue
+			}
+
+			data[i][j], err = decodeCell(v, c)
+			if err != nil {
+				return fmt.Errorf("fixture %s: row %d col %s: %s", r.name, i, c.name, err)
+			}
+		}
+	}
+
+	r.colType = cols
+	r.data = data
+	r.fixture = nil
+	return nil
+}
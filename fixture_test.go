@@ -0,0 +1,57 @@
+package dbtesting
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestTypedFixture(t *testing.T) {
+	r := newTestResult("users", []string{"id", "name"}, []interface{}{int64(1), "{{uuid}}"})
+	r.colType[1].databaseType, r.colType[1].scanType = "VARCHAR", reflect.TypeOf("")
+
+	// the rows of typed results are taken as is
+	for _, enc := range []Encoding{JSON, YAML} {
+		data, meta, err := enc.Marshal(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		typed, err := enc.Unmarshal(data, meta)
+		if err != nil {
+			t.Fatalf("%s: %s", enc.Ext(), err)
+		}
+		if typed.fixture != nil || typed.data[0][1] != "{{uuid}}" {
+			t.Errorf("%s: expect the template to be kept, got %v", enc.Ext(), typed.data)
+		}
+	}
+
+	data, err := Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	err = json.Unmarshal(data, &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// and must have every column
+	delete(doc["rows"].([]interface{})[0].(map[string]interface{}), "id")
+	partial, _ := json.Marshal(doc)
+	if _, err := Unmarshal(partial); err == nil {
+		t.Error("expect an error for the omitted column")
+	}
+
+	// without their types, the same rows form a fixture
+	for _, c := range doc["cols"].([]interface{}) {
+		delete(c.(map[string]interface{}), "ScanType")
+	}
+	untypedDoc, _ := json.Marshal(doc)
+	f, err := Unmarshal(untypedDoc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.fixture) != 1 || f.fixture[0]["name"] != "{{uuid}}" {
+		t.Errorf("expect a fixture, got %v", f.fixture)
+	}
+}
@@ -7,7 +7,9 @@ import (
 	"github.com/forsaken628/bsql"
 	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
+	"runtime"
 	"strings"
 	"testing"
 )
@@ -19,34 +21,182 @@ const (
 	ActiveRecord
 	ActiveApply
 	ActiveCheck
+	ActiveAuto
 )
 
 var (
 	ErrInvalidActive = errors.New("invalid active")
 	ErrRecordSuccess = errors.New("record success")
 	ErrOverWriteOn   = errors.New("overwrite should off")
+	ErrQueryChanged  = errors.New("query changed since recording, re-record")
 )
 
 type TT struct {
-	db      *sql.DB
-	testing *testing.T
+	db       *sql.DB
+	tx       *sql.Tx
+	testing  *testing.T
+	dialect  Dialect
+	encoding Encoding
+	root     string
+	naming   NamingFunc
+	// fixtureRoot is the directory of the shared fixture sets.
+	fixtureRoot string
+	useTx       bool
+	orders      map[string][]string
 }
 
-func NewTT(db *sql.DB, t *testing.T) *TT {
-	return &TT{db: db, testing: t}
+// Executor is implemented by both *sql.DB and *sql.Tx.
+type Executor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
 }
 
-func (t *TT) FetchResultFromTable(tabName string) (*Result, error) {
-	q, a := bsql.Select{
-		Table: bsql.Raw(tabName),
+type Option func(*TT)
+
+// WithTx runs everything of the TT inside a transaction which is rolled back
+// when the test finishes. The test body should use TT.Executor to see the
+// applied snapshots.
+func WithTx() Option {
+	return func(t *TT) {
+		t.useTx = true
+	}
+}
+
+func WithDialect(d Dialect) Option {
+	return func(t *TT) {
+		t.dialect = d
+	}
+}
+
+// WithEncoding sets the encoding of the snapshots saved by the TT, JSON by
+// default. Snapshots are loaded whatever their encoding.
+func WithEncoding(e Encoding) Option {
+	return func(t *TT) {
+		t.encoding = e
+	}
+}
+
+// WithRoot sets the directory holding the snapshots, DefaultRoot by default.
+// It may be outside the package to share snapshots between packages.
+func WithRoot(dir string) Option {
+	return func(t *TT) {
+		t.root = dir
+	}
+}
+
+// WithFixtureRoot sets the directory of the shared fixture sets,
+// DefaultFixtureRoot by default.
+func WithFixtureRoot(dir string) Option {
+	return func(t *TT) {
+		t.fixtureRoot = dir
+	}
+}
+
+// WithNaming sets how the snapshots of a test are laid out under the root,
+// DefaultNaming by default.
+func WithNaming(fn NamingFunc) Option {
+	return func(t *TT) {
+		t.naming = fn
+	}
+}
+
+func NewTT(db *sql.DB, t *testing.T, opts ...Option) *TT {
+	tt := &TT{
+		db:          db,
+		testing:     t,
+		dialect:     MySQL,
+		encoding:    JSON,
+		root:        DefaultRoot,
+		naming:      DefaultNaming,
+		fixtureRoot: DefaultFixtureRoot,
+		orders:      make(map[string][]string),
+	}
+	for _, opt := range opts {
+		opt(tt)
+	}
+
+	if tt.useTx {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		tt.tx = tx
+		t.Cleanup(func() {
+			err := tx.Rollback()
+			if err != nil && err != sql.ErrTxDone {
+				t.Error(err)
+			}
+		})
+	}
+
+	return tt
+}
+
+func (t *TT) snapshotDir(name string) string {
+	dir := filepath.Join(t.root, t.naming(t.testing.Name(), name))
+
+	// names used to be lowercased
+	if lower := strings.ToLower(name); lower != name {
+		legacy := filepath.Join(t.root, t.naming(t.testing.Name(), lower))
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			if _, err := os.Stat(legacy); err == nil {
+				return legacy
+			}
+		}
+	}
+
+	return dir
+}
+
+func (t *TT) tableOrder(tabName string) ([]string, error) {
+	if o, ok := t.orders[tabName]; ok {
+		return o, nil
+	}
+
+	cols, err := t.dialect.Columns(t.Executor(), tabName)
+	if err != nil {
+		return nil, err
+	}
+
+	o := OrderColumns(cols)
+	t.orders[tabName] = o
+	return o, nil
+}
+
+func (t *TT) tableQuery(tabName string, order []string) (string, error) {
+	if len(order) == 0 {
+		var err error
+		order, err = t.tableOrder(tabName)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	quoted := make([]string, len(order))
+	for i, c := range order {
+		quoted[i] = t.dialect.Quote(c)
+	}
+
+	q, _ := bsql.Select{
+		Table:   bsql.Raw(t.dialect.Quote(tabName)),
+		OrderBy: quoted,
 	}.Build()
+	return q, nil
+}
+
+func (t *TT) FetchResultFromTable(tabName string) (*Result, error) {
+	q, err := t.tableQuery(tabName, nil)
+	if err != nil {
+		return nil, err
+	}
 
-	r, err := t.db.Query(q, a...)
+	r, err := t.Executor().Query(q)
 	if err != nil {
 		return nil, err
 	}
 
-	rows, err := Scan(r)
+	rows, err := scan(r, t.dialect)
 	if err != nil {
 		return nil, err
 	}
@@ -54,9 +204,32 @@ func (t *TT) FetchResultFromTable(tabName string) (*Result, error) {
 	rows.name = tabName
 	rows.isTable = true
 
+	err = t.fullTypes(rows)
+	if err != nil {
+		return nil, err
+	}
+
 	return rows, nil
 }
 
+// fullTypes records the full column types of a table result, as the database
+// types of the driver miss some, like ENUM and SET columns reported as CHAR.
+func (t *TT) fullTypes(r *Result) error {
+	cols, err := t.dialect.Columns(t.Executor(), r.name)
+	if err != nil {
+		return err
+	}
+
+	full := make(map[string]string, len(cols))
+	for _, c := range cols {
+		full[c.ColumnName] = c.ColumnType
+	}
+	for _, c := range r.colType {
+		c.fullDatabaseType = full[c.name]
+	}
+	return nil
+}
+
 func (t *TT) NewSnapshotFromTables(name string, tables []string) (*Snapshot, error) {
 	qs := make([]*Query, len(tables))
 	for i, tn := range tables {
@@ -67,28 +240,55 @@ func (t *TT) NewSnapshotFromTables(name string, tables []string) (*Snapshot, err
 }
 
 func (t *TT) NewSnapshotFromQuery(name string, queries []*Query) (*Snapshot, error) {
-	name = clearName(name)
+	err := checkName(name)
+	if err != nil {
+		return nil, err
+	}
 
 	s := &Snapshot{
 		name:     name,
 		testName: t.testing.Name(),
-		results:  make(map[string]*Result, len(queries)),
+		dir:      t.snapshotDir(name),
+		dialect:  t.dialect,
+		encoding: t.encoding,
+		results:  make([]*Result, 0, len(queries)),
 	}
 
 	for _, q := range queries {
-		rows, err := t.db.Query(q.query)
+		query := q.query
+		if q.isTable {
+			var err error
+			query, err = t.tableQuery(q.name, q.keys)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		rows, err := t.Executor().Query(query)
 		if err != nil {
 			return nil, err
 		}
 
-		s.results[q.name], err = Scan(rows)
+		r, err := scan(rows, t.dialect)
 		if err != nil {
 			return nil, err
 		}
 
-		s.results[q.name].name = q.name
-		s.results[q.name].isTable = q.isTable
-		s.results[q.name].query = q
+		r.name = q.name
+		r.isTable = q.isTable
+		r.query = q
+		if q.isTable {
+			err = t.fullTypes(r)
+			if err != nil {
+				return nil, err
+			}
+		}
+		s.results = append(s.results, r)
+	}
+
+	err = checkResultNames(s.results)
+	if err != nil {
+		return nil, err
 	}
 
 	return s, nil
@@ -100,40 +300,100 @@ func (t *TT) ApplySnapshot(name string) error {
 		return err
 	}
 
-	return s.Apply(t.db)
+	return s.Apply(t.Executor())
 }
 
 func (t *TT) LoadSnapshot(name string) (*Snapshot, error) {
-	name = clearName(name)
+	err := checkName(name)
+	if err != nil {
+		return nil, err
+	}
 
+	s, err := t.loadSnapshot(name, t.snapshotDir(name))
+	if err != nil {
+		return nil, err
+	}
+
+	err = resolveFixtures(t.Executor(), t.dialect, s.results)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// LoadFixtures loads the shared fixture sets, layered in order: the tables of
+// a set replace the ones of the previous sets.
+func (t *TT) LoadFixtures(sets ...string) (*Snapshot, error) {
+	s, err := t.loadFixtures(sets)
+	if err != nil {
+		return nil, err
+	}
+
+	err = resolveFixtures(t.Executor(), t.dialect, s.results)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (t *TT) loadFixtures(sets []string) (*Snapshot, error) {
+	s := &Snapshot{testName: t.testing.Name(), dialect: t.dialect}
+	for _, set := range sets {
+		l, err := t.loadSnapshot(set, filepath.Join(t.fixtureRoot, set))
+		if err != nil {
+			return nil, err
+		}
+		s.layer(l)
+	}
+	return s, nil
+}
+
+// loadSnapshot loads the snapshot of dir, leaving its fixtures unresolved so
+// that they may refer to the ones of other layers.
+func (t *TT) loadSnapshot(name, dir string) (*Snapshot, error) {
 	s := &Snapshot{
 		name:     name,
 		testName: t.testing.Name(),
-		results:  make(map[string]*Result),
+		dir:      dir,
+		dialect:  t.dialect,
 	}
 
-	f, err := os.Open(filepath.Join("testdata/snapshot", s.testName, name))
+	var err error
+	s.results, err = loadResults(s.dir)
 	if err != nil {
 		return nil, err
 	}
 
-	fis, err := f.Readdir(0)
-	f.Close()
+	for _, r := range s.results {
+		r.dialect = t.dialect
+	}
+
+	return s, nil
+}
+
+// initialSnapshot layers the snapshot of the test over the fixture sets of
+// args. The snapshot of the test is optional when there are fixture sets.
+func (t *TT) initialSnapshot(args *InitialArgs) (*Snapshot, error) {
+	if len(args.Fixtures) == 0 {
+		return t.loadSnapshot(args.Name, t.snapshotDir(args.Name))
+	}
+
+	s, err := t.loadFixtures(args.Fixtures)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, v := range fis {
-		if !v.IsDir() {
-			rows, err := Load(filepath.Join("testdata/snapshot", s.testName, name, v.Name()))
-			if err != nil {
-				return nil, err
-			}
-
-			s.results[v.Name()] = rows
-		}
+	own, err := t.loadSnapshot(args.Name, t.snapshotDir(args.Name))
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
 	}
 
+	s.layer(own)
 	return s, nil
 }
 
@@ -141,16 +401,38 @@ func (t *TT) DB() *sql.DB {
 	return t.db
 }
 
+// Tx returns the transaction of a TT created WithTx, or nil.
+func (t *TT) Tx() *sql.Tx {
+	return t.tx
+}
+
+// Executor returns the transaction of a TT created WithTx, or its database.
+func (t *TT) Executor() Executor {
+	if t.tx != nil {
+		return t.tx
+	}
+	return t.db
+}
+
 type InitialArgs struct {
 	Active    active
 	Name      string
 	Tables    []string
 	OverWrite bool
+	// Fixtures are shared fixture sets applied in order before the snapshot
+	// of the test, see LoadFixtures. Only the snapshot of the test is recorded.
+	Fixtures []string
 }
 
 func (t *TT) Initial(args *InitialArgs) bool {
 	t.testing.Helper()
-	if args.Active != ActiveRecord && args.OverWrite {
+	act, _, overWrite, err := resolveActive(args.Active, true)
+	if err != nil {
+		t.testing.Error(err)
+		return true
+	}
+
+	if act != ActiveRecord && args.OverWrite {
 		t.testing.Error(ErrOverWriteOn)
 		return true
 	}
@@ -158,9 +440,13 @@ func (t *TT) Initial(args *InitialArgs) bool {
 	if args.Name == "" {
 		args.Name = "initial"
 	}
-	args.Name = clearName(args.Name)
+	err = checkName(args.Name)
+	if err != nil {
+		t.testing.Error(err)
+		return true
+	}
 
-	switch args.Active {
+	switch act {
 
 	case ActiveSkip:
 		t.testing.Log("skip initial")
@@ -172,7 +458,7 @@ func (t *TT) Initial(args *InitialArgs) bool {
 			t.testing.Error(err)
 			return true
 		}
-		err = s.Save(args.OverWrite)
+		err = s.Save(args.OverWrite || overWrite)
 		if err != nil {
 			t.testing.Error(err)
 			return true
@@ -181,7 +467,12 @@ func (t *TT) Initial(args *InitialArgs) bool {
 		return true
 
 	case ActiveApply:
-		err := t.ApplySnapshot(args.Name)
+		s, err := t.initialSnapshot(args)
+		if err != nil {
+			t.testing.Error(err)
+			return true
+		}
+		err = s.Apply(t.Executor())
 		if err != nil {
 			t.testing.Error(err)
 			return true
@@ -200,16 +491,95 @@ type Query struct {
 	isTable     bool
 	query       string
 	comparators map[string]Comparator
+	// names are the ones of the comparators, recorded to detect their changes.
+	names     map[string]string
+	keys      []string
+	unordered bool
+	// floatTolerance is the one of SetFloatTolerance, or nil for the default.
+	floatTolerance *floatTolerance
+}
+
+// SetKeys makes the check match rows by the given columns instead of by
+// position.
+func (q *Query) SetKeys(cols ...string) {
+	q.keys = cols
 }
 
+// SetUnordered makes the check match rows as a multiset, ignoring their order.
+func (q *Query) SetUnordered() {
+	q.unordered = true
+}
+
+// RegisterComparator checks the column col with fn. Snapshots record fn by
+// the name of its function, or of the one declaring it for closures, and fail
+// with ErrQueryChanged once it differs: changing only the arguments of a
+// constructor like Regexp goes unnoticed, see RegisterNamedComparator.
 func (q *Query) RegisterComparator(col string, fn Comparator) {
+	q.registerComparator(col, funcName(fn), fn)
+}
+
+// RegisterNamedComparator is RegisterComparator recording name along with the
+// function of fn: change it along with the arguments of fn, e.g.
+// "Regexp(^[a-z]+$)". Replacing fn by another closure declared by the same
+// function, under the same name, goes unnoticed.
+func (q *Query) RegisterNamedComparator(col, name string, fn Comparator) {
+	q.registerComparator(col, name+" ("+funcName(fn)+")", fn)
+}
+
+func (q *Query) registerComparator(col, name string, fn Comparator) {
 	if q.comparators == nil {
-		q.comparators = map[string]Comparator{col: fn}
-		return
+		q.comparators = map[string]Comparator{}
+		q.names = map[string]string{}
 	}
 	q.comparators[col] = fn
+	q.names[col] = name
+}
+
+// closureSuffix ends the names of closures, numbered in order of declaration
+// within their function.
+var closureSuffix = regexp.MustCompile(`(\.func\d+)(\.\d+)*$`)
+
+// funcName gives the name of the function of fn, or of the one declaring it
+// for closures, whose numbers change with the closures around them.
+func funcName(fn Comparator) string {
+	name := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+	return closureSuffix.ReplaceAllString(name, "")
 }
 
+func (q *Query) comparatorNames() map[string]string {
+	if len(q.names) == 0 {
+		return nil
+	}
+	return q.names
+}
+
+// checkDrift reports whether the query or its comparators were changed after
+// the result was recorded.
+func (q *Query) checkDrift(r *Result) error {
+	if r.recorded == nil {
+		return nil
+	}
+
+	if r.recorded.query != q.query {
+		return fmt.Errorf("result %s: %w\nrecorded: %s\ncurrent:  %s", q.name, ErrQueryChanged, r.recorded.query, q.query)
+	}
+
+	names := q.comparatorNames()
+	if len(names) != len(r.recorded.comparators) {
+		return fmt.Errorf("result %s: %w, comparators: recorded %v, current %v", q.name, ErrQueryChanged, r.recorded.comparators, names)
+	}
+	for col, name := range names {
+		if r.recorded.comparators[col] != name {
+			return fmt.Errorf("result %s: %w, comparators: recorded %v, current %v", q.name, ErrQueryChanged, r.recorded.comparators, names)
+		}
+	}
+
+	return nil
+}
+
+// NewQuery checks the result of the query q. Its columns are typed by the
+// driver alone, which reports SET columns as CHAR: register SetEqual on them
+// to ignore the order of their members, as done for tables.
 func NewQuery(name, q string) *Query {
 	return &Query{name: name, query: q}
 }
@@ -227,18 +597,29 @@ type CheckQueryArgs struct {
 	Name      string
 	Queries   []*Query
 	OverWrite bool
+	MaxDiff   int
 }
 
 func (t *TT) CheckQuery(args *CheckQueryArgs) bool {
 	t.testing.Helper()
-	if args.Active != ActiveRecord && args.OverWrite {
+	act, update, overWrite, err := resolveActive(args.Active, false)
+	if err != nil {
+		t.testing.Error(err)
+		return true
+	}
+
+	if act != ActiveRecord && args.OverWrite {
 		t.testing.Error(ErrOverWriteOn)
 		return true
 	}
 
-	args.Name = clearName(args.Name)
+	err = checkName(args.Name)
+	if err != nil {
+		t.testing.Error(err)
+		return true
+	}
 
-	switch args.Active {
+	switch act {
 
 	case ActiveSkip:
 		t.testing.Log("skip check")
@@ -250,7 +631,16 @@ func (t *TT) CheckQuery(args *CheckQueryArgs) bool {
 			t.testing.Error(err)
 			return true
 		}
-		err = s.Save(args.OverWrite)
+		if update {
+			summary, err := s.Update()
+			if err != nil {
+				t.testing.Error(err)
+				return true
+			}
+			t.testing.Logf("update snapshot %s: %s", args.Name, summary)
+			return false
+		}
+		err = s.Save(args.OverWrite || overWrite)
 		if err != nil {
 			t.testing.Error(err)
 			return true
@@ -266,7 +656,14 @@ func (t *TT) CheckQuery(args *CheckQueryArgs) bool {
 		}
 
 		for _, q := range args.Queries {
-			s0.results[q.name].query = q
+			if r := s0.result(q.name); r != nil {
+				err = q.checkDrift(r)
+				if err != nil {
+					t.testing.Error(err)
+					return true
+				}
+				r.query = q
+			}
 		}
 
 		s1, err := t.NewSnapshotFromQuery(args.Name, args.Queries)
@@ -275,9 +672,13 @@ func (t *TT) CheckQuery(args *CheckQueryArgs) bool {
 			return true
 		}
 
-		diff, same := CompareSnapshot(s0, s1)
-		if !same {
-			t.testing.Error(diff)
+		diff := DiffSnapshot(s0, s1)
+		if diff != nil {
+			maxDiff := args.MaxDiff
+			if maxDiff == 0 {
+				maxDiff = DefaultMaxDiff
+			}
+			t.testing.Errorf("check snapshot %s fail:\n%s", args.Name, diff.Format(maxDiff))
 			return true
 		}
 		return false
@@ -287,12 +688,3 @@ func (t *TT) CheckQuery(args *CheckQueryArgs) bool {
 		return true
 	}
 }
-
-var nameReg = regexp.MustCompile(`^[\w_-]+$`)
-
-func clearName(name string) string {
-	if nameReg.MatchString(name) {
-		return strings.ToLower(name)
-	}
-	panic("invalid name")
-}
@@ -3,8 +3,9 @@ module github.com/forsaken628/dbtesting
 require (
 	github.com/forsaken628/bsql v0.0.0-20181206095015-2c70ea7774c5
 	github.com/go-sql-driver/mysql v1.4.1
-	github.com/mattn/go-sqlite3 v1.10.0 // indirect
+	github.com/mattn/go-sqlite3 v1.10.0
 	golang.org/x/crypto v0.0.0-20181106171534-e4dc69e5b2fd
 	golang.org/x/sys v0.0.0-20181211161752-7da8ea5c8182 // indirect
 	google.golang.org/appengine v1.3.0 // indirect
+	gopkg.in/yaml.v2 v2.2.2
 )
@@ -0,0 +1,214 @@
+package dbtesting
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	ManifestFile    = "manifest.json"
+	manifestVersion = 1
+)
+
+type Manifest struct {
+	Version   int              `json:"version"`
+	CreatedAt time.Time        `json:"createdAt"`
+	Results   []ManifestResult `json:"results"`
+}
+
+type ManifestResult struct {
+	Name string `json:"name"`
+	File string `json:"file"`
+	// Meta is the sidecar file holding the column types of the encodings
+	// unable to hold them.
+	Meta  string `json:"meta,omitempty"`
+	Query string `json:"query,omitempty"`
+}
+
+func newManifest(results []*Result) *Manifest {
+	m := &Manifest{
+		Version:   manifestVersion,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+		Results:   make([]ManifestResult, len(results)),
+	}
+
+	for i, r := range results {
+		m.Results[i] = ManifestResult{Name: r.name, File: r.name}
+		if r.query != nil {
+			m.Results[i].Query = r.query.query
+		}
+	}
+
+	return m
+}
+
+func LoadManifest(dir string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{}
+	err = json.Unmarshal(data, m)
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %s", dir, err)
+	}
+	if m.Version != manifestVersion {
+		return nil, fmt.Errorf("unsupported manifest version %d in %s", m.Version, dir)
+	}
+
+	return m, nil
+}
+
+func (m *Manifest) Save(dir string) error {
+	data, err := m.marshal()
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, ManifestFile), data, 0644)
+}
+
+func (m *Manifest) marshal() ([]byte, error) {
+	return json.MarshalIndent(m, "", "  ")
+}
+
+// loadResults loads the results listed by the manifest of dir, in order.
+// Files of dir that are not listed are rejected. Snapshots recorded before
+// manifests existed are loaded in file name order.
+func loadResults(dir string) ([]*Result, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	fis, err := f.Readdir(0)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]bool, len(fis))
+	for _, v := range fis {
+		if !v.IsDir() {
+			files[v.Name()] = true
+		}
+	}
+
+	m, err := LoadManifest(dir)
+	if os.IsNotExist(err) {
+		return loadLegacyResults(dir, files)
+	}
+	if err != nil {
+		return nil, err
+	}
+	delete(files, ManifestFile)
+
+	results := make([]*Result, len(m.Results))
+	for i, v := range m.Results {
+		if !files[v.File] {
+			return nil, fmt.Errorf("missing file %s of result %s in %s", v.File, v.Name, dir)
+		}
+		delete(files, v.File)
+
+		meta := ""
+		if v.Meta != "" {
+			if !files[v.Meta] {
+				return nil, fmt.Errorf("missing file %s of result %s in %s", v.Meta, v.Name, dir)
+			}
+			delete(files, v.Meta)
+			meta = filepath.Join(dir, v.Meta)
+		}
+
+		results[i], err = load(filepath.Join(dir, v.File), meta)
+		if err != nil {
+			return nil, err
+		}
+		results[i].name = v.Name
+	}
+
+	if len(files) > 0 {
+		unknown := make([]string, 0, len(files))
+		for name := range files {
+			unknown = append(unknown, name)
+		}
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown files %s in %s", strings.Join(unknown, ", "), dir)
+	}
+
+	return results, nil
+}
+
+// resultExts are the extensions of the encodings, trimmed from the file names
+// of results without manifest.
+var resultExts = []string{".json", ".yaml", ".yml", ".csv"}
+
+// loadLegacyResults loads the results of a snapshot without manifest, saved
+// by older versions or written by hand. Files other than the sidecars of
+// results must decode as results: stray files are rejected.
+func loadLegacyResults(dir string, files map[string]bool) ([]*Result, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		if !isSidecar(name, files) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	results := make([]*Result, len(names))
+	for i, name := range names {
+		r, err := loadLegacyResult(dir, name)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = r
+	}
+
+	return results, nil
+}
+
+// loadLegacyResult loads the file name of a snapshot without manifest, which
+// must decode as a result.
+func loadLegacyResult(dir, name string) (*Result, error) {
+	r, err := Load(filepath.Join(dir, name))
+	if err == nil && len(r.colType) == 0 && r.fixture == nil {
+		err = errors.New("no columns")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("file %s in %s is not a result: %s", name, dir, err)
+	}
+	r.name = unescapeName(trimResultExt(name))
+	return r, nil
+}
+
+// trimResultExt trims the extension of an encoding, keeping the dots of names
+// saved before they were escaped.
+func trimResultExt(name string) string {
+	ext := filepath.Ext(name)
+	for _, v := range resultExts {
+		if strings.EqualFold(ext, v) {
+			return strings.TrimSuffix(name, ext)
+		}
+	}
+	return name
+}
+
+func isSidecar(name string, files map[string]bool) bool {
+	if !strings.HasSuffix(name, ".meta.json") {
+		return false
+	}
+	base := strings.TrimSuffix(name, ".meta.json")
+	for _, ext := range resultExts {
+		if files[base+ext] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package dbtesting
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadResults(t *testing.T) {
+	dir := t.TempDir()
+
+	results := []*Result{
+		newTestResult("b", []string{"id"}, []interface{}{int64(1)}),
+		newTestResult("a", []string{"id"}, []interface{}{int64(2)}),
+	}
+	for _, r := range results {
+		data, err := Marshal(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, r.name), data, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ls, err := loadResults(dir)
+	if err != nil || len(ls) != 2 || ls[0].name != "a" {
+		t.Fatalf("legacy snapshot should load in name order: %v", err)
+	}
+
+	err = newManifest(results).Save(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ls, err = loadResults(dir)
+	if err != nil || len(ls) != 2 || ls[0].name != "b" {
+		t.Fatalf("snapshot should load in manifest order: %v", err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "stray"), []byte("{}"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = loadResults(dir)
+	if err == nil || !strings.Contains(err.Error(), "unknown files stray") {
+		t.Errorf("expect unknown file error, got %v", err)
+	}
+}
+
+func TestLoadLegacyResults(t *testing.T) {
+	dir := t.TempDir()
+
+	r := newTestResult("db.tab", []string{"id"}, []interface{}{int64(1)})
+	data, err := Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, r.name), data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ls, err := loadResults(dir)
+	if err != nil || len(ls) != 1 || ls[0].name != "db.tab" {
+		t.Fatalf("legacy result should keep its dotted name: %v", err)
+	}
+
+	for _, v := range []struct{ name, data string }{
+		{"README.md", "# fixtures"},
+		{".DS_Store", "\x00\x00\x00\x01Bud1"},
+		{"empty.json", "{}"},
+	} {
+		path := filepath.Join(dir, v.name)
+		err = ioutil.WriteFile(path, []byte(v.data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = loadResults(dir)
+		if err == nil || !strings.Contains(err.Error(), "file "+v.name+" in ") {
+			t.Errorf("expect %s to be rejected, got %v", v.name, err)
+		}
+
+		err = os.Remove(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
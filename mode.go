@@ -0,0 +1,81 @@
+package dbtesting
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type Mode string
+
+const (
+	ModeCheck  = Mode("check")
+	ModeRecord = Mode("record")
+	ModeUpdate = Mode("update")
+)
+
+const ModeEnv = "DBTESTING_MODE"
+
+var ErrInvalidMode = errors.New("invalid mode")
+
+var (
+	flagMode   = flag.String("dbtesting.mode", "", "snapshot mode for ActiveAuto: record, check or update")
+	flagUpdate = flag.Bool("dbtesting.update", false, "shorthand for -dbtesting.mode=update")
+)
+
+// CurrentMode resolves the mode used by ActiveAuto. The -dbtesting.update and
+// -dbtesting.mode flags take precedence over the DBTESTING_MODE environment
+// variable, and ModeCheck is used when none of them is set.
+func CurrentMode() (Mode, error) {
+	if *flagUpdate {
+		return ModeUpdate, nil
+	}
+
+	m := *flagMode
+	if m == "" {
+		m = os.Getenv(ModeEnv)
+	}
+
+	switch Mode(strings.ToLower(m)) {
+	case "", ModeCheck:
+		return ModeCheck, nil
+	case ModeRecord:
+		return ModeRecord, nil
+	case ModeUpdate:
+		return ModeUpdate, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrInvalidMode, m)
+	}
+}
+
+// resolveActive maps ActiveAuto to a concrete active for Initial (initial == true)
+// or CheckQuery. update is true when recorded snapshots should be overwritten
+// without failing the test, and overWrite when existing snapshots should be
+// recorded again, as asked by ModeRecord.
+func resolveActive(a active, initial bool) (act active, update, overWrite bool, err error) {
+	if a != ActiveAuto {
+		return a, false, false, nil
+	}
+
+	m, err := CurrentMode()
+	if err != nil {
+		return a, false, false, err
+	}
+
+	switch m {
+	case ModeRecord:
+		return ActiveRecord, false, true, nil
+	case ModeUpdate:
+		if initial {
+			return ActiveApply, false, false, nil
+		}
+		return ActiveRecord, true, true, nil
+	default:
+		if initial {
+			return ActiveApply, false, false, nil
+		}
+		return ActiveCheck, false, false, nil
+	}
+}
@@ -0,0 +1,48 @@
+package dbtesting
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestResolveActive(t *testing.T) {
+	defer os.Unsetenv(ModeEnv)
+
+	cases := []struct {
+		env       string
+		initial   bool
+		act       active
+		update    bool
+		overWrite bool
+	}{
+		{"", true, ActiveApply, false, false},
+		{"", false, ActiveCheck, false, false},
+		{"record", true, ActiveRecord, false, true},
+		{"record", false, ActiveRecord, false, true},
+		{"update", true, ActiveApply, false, false},
+		{"UPDATE", false, ActiveRecord, true, true},
+	}
+
+	for _, c := range cases {
+		os.Setenv(ModeEnv, c.env)
+		act, update, overWrite, err := resolveActive(ActiveAuto, c.initial)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if act != c.act || update != c.update || overWrite != c.overWrite {
+			t.Errorf("mode %q initial %v: got %v %v %v", c.env, c.initial, act, update, overWrite)
+		}
+	}
+
+	os.Setenv(ModeEnv, "recrod")
+	if _, _, _, err := resolveActive(ActiveAuto, false); !errors.Is(err, ErrInvalidMode) || !strings.Contains(err.Error(), `"recrod"`) {
+		t.Errorf("expect ErrInvalidMode with the mode, got %v", err)
+	}
+
+	if act, _, overWrite, _ := resolveActive(ActiveCheck, true); act != ActiveCheck || overWrite {
+		t.Errorf("explicit active should be kept, got %v", act)
+	}
+}
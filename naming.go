@@ -0,0 +1,124 @@
+package dbtesting
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultRoot is the directory holding the snapshots, relative to the
+	// package.
+	DefaultRoot = "testdata/snapshot"
+	// DefaultFixtureRoot is the directory holding the shared fixture sets,
+	// one directory per set.
+	DefaultFixtureRoot = "testdata/fixtures"
+)
+
+// NamingFunc gives the directory of the snapshot name of a test, relative to
+// the root.
+type NamingFunc func(testName, name string) string
+
+// DefaultNaming nests the snapshots of subtests under the ones of their
+// parent, like TestX/sub_case/name. Characters other than letters, digits,
+// '_', '-' and '.' are percent-encoded, as well as leading dots.
+func DefaultNaming(testName, name string) string {
+	segs := strings.Split(testName, "/")
+	for i, s := range segs {
+		segs[i] = escapeSegment(s)
+	}
+	return filepath.Join(append(segs, escapeSegment(name))...)
+}
+
+// SharedNaming ignores the test, so that every test and subtest using the
+// same root shares the snapshots of a name.
+func SharedNaming(_, name string) string {
+	return escapeSegment(name)
+}
+
+var ErrInvalidName = errors.New("invalid name")
+
+// checkName validates a snapshot or result name. Names may hold any printable
+// character, the ones unsafe in file names are escaped.
+func checkName(name string) error {
+	if name == "" {
+		return fmt.Errorf("%w: empty", ErrInvalidName)
+	}
+	if !utf8.ValidString(name) {
+		return fmt.Errorf("%w %q: not UTF-8", ErrInvalidName, name)
+	}
+	for _, r := range name {
+		if !unicode.IsPrint(r) {
+			return fmt.Errorf("%w %q: unprintable character %q", ErrInvalidName, name, r)
+		}
+	}
+	if len(escapeName(name)) > maxNameLen {
+		return fmt.Errorf("%w %q: longer than %d bytes once escaped", ErrInvalidName, name, maxNameLen)
+	}
+	return nil
+}
+
+// maxNameLen keeps the file names within the limits of common filesystems,
+// with room for the extensions.
+const maxNameLen = 200
+
+// checkResultNames validates the names of the results, which must be unique
+// even ignoring case, as their files collide on case-insensitive filesystems.
+func checkResultNames(results []*Result) error {
+	seen := make(map[string]string, len(results))
+	for _, r := range results {
+		err := checkName(r.name)
+		if err != nil {
+			return err
+		}
+
+		key := strings.ToLower(r.name)
+		if other, ok := seen[key]; ok {
+			if other == r.name {
+				return fmt.Errorf("%w %q: duplicate result", ErrInvalidName, r.name)
+			}
+			return fmt.Errorf("%w %q: collides with %q ignoring case", ErrInvalidName, r.name, other)
+		}
+		seen[key] = r.name
+	}
+	return nil
+}
+
+// escapeName gives the file name of a result. Characters other than letters,
+// digits, '_' and '-' are percent-encoded, so that "schema.table" is saved as
+// "schema%2Etable".
+func escapeName(name string) string {
+	return escape(name, false)
+}
+
+func unescapeName(file string) string {
+	name, err := url.PathUnescape(file)
+	if err != nil {
+		return file
+	}
+	return name
+}
+
+func escapeSegment(s string) string {
+	return escape(s, true)
+}
+
+func escape(s string, keepDot bool) string {
+	b := &strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_', c == '-':
+			b.WriteByte(c)
+		case c == '.' && keepDot && i > 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
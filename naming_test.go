@@ -0,0 +1,56 @@
+package dbtesting
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckName(t *testing.T) {
+	for _, name := range []string{"", "a\x00b", "\xff"} {
+		err := checkName(name)
+		if !errors.Is(err, ErrInvalidName) {
+			t.Errorf("%q: expect invalid name, got %v", name, err)
+		}
+	}
+
+	for _, name := range []string{"initial", "Foo-1", "schema.table", "a/b c"} {
+		err := checkName(name)
+		if err != nil {
+			t.Errorf("%q: %s", name, err)
+		}
+		if unescapeName(escapeName(name)) != name {
+			t.Errorf("%q: escaped as %q", name, escapeName(name))
+		}
+	}
+
+	if f := escapeName("schema.table"); f != "schema%2Etable" {
+		t.Errorf("unexpected file name %s", f)
+	}
+
+	if p := DefaultNaming("TestX/a.b/..", "x/y"); p != filepath.Join("TestX", "a.b", "%2E.", "x%2Fy") {
+		t.Errorf("unexpected path %s", p)
+	}
+}
+
+func TestCheckResultNames(t *testing.T) {
+	for _, v := range []struct {
+		names []string
+		ok    bool
+	}{
+		{[]string{"users", "Orders"}, true},
+		{[]string{"users", "users"}, false},
+		{[]string{"Foo", "foo"}, false},
+		{[]string{"users", ""}, false},
+	} {
+		results := make([]*Result, len(v.names))
+		for i, name := range v.names {
+			results[i] = newTestResult(name, []string{"id"})
+		}
+
+		err := checkResultNames(results)
+		if (err == nil) != v.ok {
+			t.Errorf("%v: unexpected error %v", v.names, err)
+		}
+	}
+}
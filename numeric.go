@@ -0,0 +1,169 @@
+package dbtesting
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+)
+
+// SetFloatTolerance makes the check consider floats equal when they are
+// within ulps units in the last place, or within epsilon of each other.
+// Without it, floats are only equal when exactly equal.
+func (q *Query) SetFloatTolerance(ulps uint64, epsilon float64) {
+	q.floatTolerance = &floatTolerance{ulps: ulps, epsilon: epsilon}
+}
+
+type floatTolerance struct {
+	ulps    uint64
+	epsilon float64
+}
+
+func (q *Query) tolerance() floatTolerance {
+	if q == nil || q.floatTolerance == nil {
+		return floatTolerance{}
+	}
+	return *q.floatTolerance
+}
+
+// numericComparator compares the values of DECIMAL columns by exact value,
+// so that 1.50 equals 1.5 but not 1.51, and the ones of floats with the
+// tolerance of q.
+func numericComparator(col *ColType, q *Query) Comparator {
+	switch {
+	case isDecimal(col.databaseType):
+		return compareDecimal
+	case isFloat(col.scanType):
+		tol := q.tolerance()
+		return func(expect, actual interface{}) (string, bool) {
+			return compareFloat(expect, actual, tol)
+		}
+	default:
+		return nil
+	}
+}
+
+func isDecimal(databaseType string) bool {
+	typ := strings.ToUpper(databaseType)
+	return strings.HasPrefix(typ, "DECIMAL") || strings.HasPrefix(typ, "NUMERIC")
+}
+
+func isFloat(typ reflect.Type) bool {
+	if typ == nil {
+		return false
+	}
+	return typ == reflect.TypeOf(sql.NullFloat64{}) || typ.Kind() == reflect.Float32 || typ.Kind() == reflect.Float64
+}
+
+// compareDecimal compares decimal strings by value.
+func compareDecimal(expect, actual interface{}) (string, bool) {
+	es, eok := decimalString(expect)
+	as, aok := decimalString(actual)
+	if eok != aok {
+		return fmt.Sprintf("expect: %v, actual: %v", formatValue(expect), formatValue(actual)), false
+	}
+	if !eok {
+		return "", true
+	}
+
+	er, ok1 := new(big.Rat).SetString(es)
+	ar, ok2 := new(big.Rat).SetString(as)
+	if !ok1 || !ok2 {
+		if es != as {
+			return fmt.Sprintf("expect: %s, actual: %s", es, as), false
+		}
+		return "", true
+	}
+
+	if er.Cmp(ar) != 0 {
+		return fmt.Sprintf("expect: %s, actual: %s", es, as), false
+	}
+	return "", true
+}
+
+// decimalString returns the string of a DECIMAL value, false when NULL.
+func decimalString(v interface{}) (string, bool) {
+	switch vv := v.(type) {
+	case string:
+		return vv, true
+	case sql.NullString:
+		return vv.String, vv.Valid
+	case nil:
+		return "", false
+	default:
+		return fmt.Sprint(vv), true
+	}
+}
+
+func compareFloat(expect, actual interface{}, tol floatTolerance) (string, bool) {
+	ef, eok, e32 := floatValue(expect)
+	af, aok, a32 := floatValue(actual)
+	if eok != aok {
+		return fmt.Sprintf("expect: %v, actual: %v", formatValue(expect), formatValue(actual)), false
+	}
+	if !eok || ef == af || (math.IsNaN(ef) && math.IsNaN(af)) {
+		return "", true
+	}
+
+	if math.Abs(ef-af) <= tol.epsilon {
+		return "", true
+	}
+
+	var ulps uint64
+	if e32 && a32 {
+		ulps = ulpDistance(int64(orderedFloat32(float32(ef))), int64(orderedFloat32(float32(af))))
+	} else {
+		ulps = ulpDistance(orderedFloat64(ef), orderedFloat64(af))
+	}
+	if ulps <= tol.ulps {
+		return "", true
+	}
+
+	return fmt.Sprintf("expect: %v, actual: %v, %d ulps apart", ef, af, ulps), false
+}
+
+// floatValue returns the value of a float, false when NULL, and whether it
+// is a float32.
+func floatValue(v interface{}) (f float64, valid bool, is32 bool) {
+	switch vv := v.(type) {
+	case float64:
+		return vv, true, false
+	case float32:
+		return float64(vv), true, true
+	case sql.NullFloat64:
+		return vv.Float64, vv.Valid, false
+	default:
+		rv := reflect.ValueOf(v)
+		if !rv.IsValid() || (rv.Kind() != reflect.Float32 && rv.Kind() != reflect.Float64) {
+			return 0, false, false
+		}
+		return rv.Float(), true, rv.Kind() == reflect.Float32
+	}
+}
+
+// orderedFloat64 maps floats to integers of the same order, adjacent floats
+// being adjacent integers.
+func orderedFloat64(f float64) int64 {
+	b := math.Float64bits(f)
+	if b>>63 == 1 {
+		return -int64(b &^ (1 << 63))
+	}
+	return int64(b)
+}
+
+func orderedFloat32(f float32) int32 {
+	b := math.Float32bits(f)
+	if b>>31 == 1 {
+		return -int32(b &^ (1 << 31))
+	}
+	return int32(b)
+}
+
+func ulpDistance(a, b int64) uint64 {
+	if a > b {
+		a, b = b, a
+	}
+	return uint64(b) - uint64(a)
+}
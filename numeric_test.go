@@ -0,0 +1,83 @@
+package dbtesting
+
+import (
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompareDecimal(t *testing.T) {
+	for _, v := range []struct {
+		expect, actual interface{}
+		same           bool
+	}{
+		{"1.50", "1.5", true},
+		{"1.5", "1.6", false},
+		{"1.505", "1.51", false},
+		{"-1.510", "-1.51", true},
+		{sql.NullString{String: "2", Valid: true}, sql.NullString{String: "2.00", Valid: true}, true},
+		{sql.NullString{}, sql.NullString{String: "0", Valid: true}, false},
+		{sql.NullString{}, sql.NullString{}, true},
+	} {
+		_, same := compareDecimal(v.expect, v.actual)
+		if same != v.same {
+			t.Errorf("%v vs %v: expect same %t", v.expect, v.actual, v.same)
+		}
+	}
+}
+
+func TestCompareFloat(t *testing.T) {
+	def := (*Query)(nil).tolerance()
+	a, b := 0.1, 0.2
+	a32, b32 := float32(0.1), float32(0.2)
+	for _, v := range []struct {
+		expect, actual interface{}
+		tol            floatTolerance
+		same           bool
+	}{
+		{a + b, 0.3, def, false},
+		{a + b, 0.3, floatTolerance{ulps: 4}, true},
+		{0.3, 0.3, def, true},
+		{1.0, 1.1, floatTolerance{ulps: 4}, false},
+		{1.0, 1.1, floatTolerance{epsilon: 0.2}, true},
+		{a32 + b32, float32(0.3), floatTolerance{ulps: 4}, true},
+		{sql.NullFloat64{}, sql.NullFloat64{Float64: 0, Valid: true}, def, false},
+		{-0.0, 0.0, floatTolerance{}, true},
+	} {
+		_, same := compareFloat(v.expect, v.actual, v.tol)
+		if same != v.same {
+			t.Errorf("%v vs %v: expect same %t", v.expect, v.actual, v.same)
+		}
+	}
+}
+
+func TestDiffNumeric(t *testing.T) {
+	cols := []*ColType{
+		{name: "amount", databaseType: "DECIMAL", hasPrecisionScale: true, precision: 10, scale: 2, scanType: reflect.TypeOf("")},
+		{name: "score", databaseType: "DOUBLE", scanType: reflect.TypeOf(float64(0))},
+	}
+	a, b := 0.1, 0.2
+	expect := &Result{ResultType: ResultType{name: "t", colType: cols}, data: [][]interface{}{{"1.5", 0.3}}}
+	actual := &Result{ResultType: ResultType{name: "t", colType: cols}, data: [][]interface{}{{"1.50", a + b}}}
+
+	// floats are compared exactly by default
+	if d := DiffResult(expect, actual); d == nil || len(d.Changed) != 1 || d.Changed[0].Cells[0].Column != "score" {
+		t.Errorf("expect score diff without tolerance, got %v", d)
+	}
+
+	q := NewQuery("t", "select 1")
+	q.SetFloatTolerance(4, 0)
+	expect.query = q
+	if d := DiffResult(expect, actual); d != nil {
+		t.Errorf("unexpected diff: %s", (&SnapshotDiff{Results: []*ResultDiff{d}}).String())
+	}
+
+	data, err := Marshal(expect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"score": "0.3"`) {
+		t.Errorf("floats should be saved as decimal strings:\n%s", data)
+	}
+}
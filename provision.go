@@ -0,0 +1,152 @@
+package dbtesting
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"github.com/go-sql-driver/mysql"
+	"strings"
+	"testing"
+)
+
+// Provisioner gives every test its own MySQL schema, created from the tables
+// of Template and dropped when the test finishes, so that tests using TT can
+// run in parallel.
+type Provisioner struct {
+	// DB is used to create and drop the schemas.
+	DB *sql.DB
+	// DSN is the data source name of the derived connections, its database
+	// name is replaced by the one of the test.
+	DSN      string
+	Template string
+}
+
+func (p *Provisioner) NewTT(t *testing.T, opts ...Option) *TT {
+	t.Helper()
+	db, err := p.Provision(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewTT(db, t, opts...)
+}
+
+// Provision creates the schema of the test and returns a connection to it.
+func (p *Provisioner) Provision(t *testing.T) (*sql.DB, error) {
+	cfg, err := mysql.ParseDSN(p.DSN)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := schemaName(p.Template, t.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = p.DB.Exec("create database " + MySQL.Quote(name))
+	if err != nil {
+		return nil, err
+	}
+	t.Cleanup(func() {
+		_, err := p.DB.Exec("drop database " + MySQL.Quote(name))
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	cfg.DBName = name
+	db, err := sql.Open("mysql", cfg.FormatDSN())
+	if err != nil {
+		return nil, err
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	err = p.clone(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func (p *Provisioner) clone(db *sql.DB) error {
+	rows, err := p.DB.Query("select TABLE_NAME from information_schema.TABLES where TABLE_SCHEMA = ? and TABLE_TYPE = 'BASE TABLE' order by TABLE_NAME", p.Template)
+	if err != nil {
+		return err
+	}
+
+	var tables []string
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		tables = append(tables, name)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// tables are created in name order, which may break references
+	_, err = conn.ExecContext(ctx, "set foreign_key_checks = 0")
+	if err != nil {
+		return err
+	}
+
+	for _, tab := range tables {
+		var name, ddl string
+		err = p.DB.QueryRow("show create table "+MySQL.Quote(p.Template+"."+tab)).Scan(&name, &ddl)
+		if err != nil {
+			return err
+		}
+
+		_, err = conn.ExecContext(ctx, ddl)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = conn.ExecContext(ctx, "set foreign_key_checks = 1")
+	return err
+}
+
+// schemaName derives a schema name from the template and the test name,
+// made unique by a random suffix and kept within the 64 characters allowed
+// by MySQL.
+func schemaName(template, testName string) (string, error) {
+	b := make([]byte, 4)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	suffix := "_" + hex.EncodeToString(b)
+
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		default:
+			return '_'
+		}
+	}, template+"_"+testName)
+
+	if len(name) > 64-len(suffix) {
+		name = name[:64-len(suffix)]
+	}
+
+	return name + suffix, nil
+}
@@ -0,0 +1,24 @@
+package dbtesting
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestSchemaName(t *testing.T) {
+	a, err := schemaName("test", "TestA/sub case")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := schemaName("test", "TestA/sub case")
+
+	if !regexp.MustCompile(`^test_testa_sub_case_[0-9a-f]{8}$`).MatchString(a) || a == b {
+		t.Errorf("unexpected names: %s %s", a, b)
+	}
+
+	long, _ := schemaName("test", strings.Repeat("x", 100))
+	if len(long) != 64 {
+		t.Errorf("expect 64 characters, got %d", len(long))
+	}
+}
@@ -0,0 +1,196 @@
+package dbtesting
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type CheckSchemaArgs struct {
+	Active    active
+	Name      string
+	Tables    []string
+	OverWrite bool
+	Unsafe    bool
+}
+
+func (t *TT) FetchSchema(tables []string) ([]*Table, error) {
+	ls := make([]*Table, len(tables))
+	for i, name := range tables {
+		tab, err := t.dialect.Table(t.Executor(), name)
+		if err != nil {
+			return nil, err
+		}
+		ls[i] = tab
+	}
+	return ls, nil
+}
+
+// SaveSchema writes the tables into the schema directory, see UpdateSchema.
+// An existing schema is only overwritten with overWrite.
+func (t *TT) SaveSchema(name string, tables []*Table, overWrite bool) error {
+	err := checkName(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stat(t.snapshotDir(name))
+	if !os.IsNotExist(err) && !overWrite {
+		return os.ErrExist
+	}
+
+	_, err = t.UpdateSchema(name, tables)
+	return err
+}
+
+// UpdateSchema writes the tables into the schema directory, leaving the files
+// of unchanged tables untouched. The files of other tables, and any other
+// file, are kept.
+func (t *TT) UpdateSchema(name string, tables []*Table) (*UpdateSummary, error) {
+	err := checkName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	path := t.snapshotDir(name)
+	err = os.MkdirAll(path, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &UpdateSummary{}
+	for _, tab := range tables {
+		data, err := json.MarshalIndent(tab, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+
+		file := filepath.Join(path, escapeName(tab.T.TableName))
+		_, err = os.Stat(file)
+		created := os.IsNotExist(err)
+
+		changed, err := writeIfChanged(file, data)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case created:
+			summary.Created = append(summary.Created, tab.T.TableName)
+		case changed:
+			summary.Updated = append(summary.Updated, tab.T.TableName)
+		}
+	}
+
+	return summary, nil
+}
+
+func (t *TT) LoadSchema(name string, tables []string) ([]*Table, error) {
+	err := checkName(name)
+	if err != nil {
+		return nil, err
+	}
+	path := t.snapshotDir(name)
+
+	ls := make([]*Table, len(tables))
+	for i, tn := range tables {
+		data, err := ioutil.ReadFile(filepath.Join(path, escapeName(tn)))
+		if err != nil {
+			return nil, err
+		}
+
+		ls[i] = &Table{}
+		err = json.Unmarshal(data, ls[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ls, nil
+}
+
+func (t *TT) CheckSchema(args *CheckSchemaArgs) bool {
+	t.testing.Helper()
+	act, update, overWrite, err := resolveActive(args.Active, false)
+	if err != nil {
+		t.testing.Error(err)
+		return true
+	}
+
+	if act != ActiveRecord && args.OverWrite {
+		t.testing.Error(ErrOverWriteOn)
+		return true
+	}
+
+	if args.Name == "" {
+		args.Name = "schema"
+	}
+	err = checkName(args.Name)
+	if err != nil {
+		t.testing.Error(err)
+		return true
+	}
+
+	switch act {
+
+	case ActiveSkip:
+		t.testing.Log("skip check schema")
+		return false
+
+	case ActiveRecord:
+		ls, err := t.FetchSchema(args.Tables)
+		if err != nil {
+			t.testing.Error(err)
+			return true
+		}
+		if update {
+			summary, err := t.UpdateSchema(args.Name, ls)
+			if err != nil {
+				t.testing.Error(err)
+				return true
+			}
+			t.testing.Logf("update schema %s: %s", args.Name, summary)
+			return false
+		}
+		err = t.SaveSchema(args.Name, ls, args.OverWrite || overWrite)
+		if err != nil {
+			t.testing.Error(err)
+			return true
+		}
+		t.testing.Fatal(ErrRecordSuccess)
+		return true
+
+	case ActiveCheck:
+		expect, err := t.LoadSchema(args.Name, args.Tables)
+		if err != nil {
+			t.testing.Error(err)
+			return true
+		}
+
+		actual, err := t.FetchSchema(args.Tables)
+		if err != nil {
+			t.testing.Error(err)
+			return true
+		}
+
+		var diffs []string
+		for i := range expect {
+			diff, same := CompareTable(expect[i], actual[i], args.Unsafe)
+			if !same {
+				diffs = append(diffs, fmt.Sprintf("table %s:\n  %s", args.Tables[i], strings.Join(diff, "\n  ")))
+			}
+		}
+
+		if len(diffs) > 0 {
+			t.testing.Errorf("check schema %s fail:\n%s", args.Name, strings.Join(diffs, "\n"))
+			return true
+		}
+		return false
+
+	default:
+		t.testing.Error(ErrInvalidActive)
+		return true
+	}
+}
@@ -0,0 +1,444 @@
+package dbtesting
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	_ "github.com/mattn/go-sqlite3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func getSQLite(t *testing.T, fn func(tt *TT), opts ...Option) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	for _, q := range []string{
+		"pragma foreign_keys = on",
+		"create table users (id integer primary key, name varchar(32) not null, score real, created datetime)",
+		"create index idx_users_name on users (name)",
+		"create table orders (id integer primary key, user_id integer not null references users (id), amount decimal(10,2))",
+		"insert into users values (2, 'bob', null, '2018-12-01 10:00:00'), (1, 'alice', 1.5, '2018-12-01 09:00:00')",
+	} {
+		_, err = db.Exec(q)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	fn(NewTT(db, t, append([]Option{WithDialect(SQLite)}, opts...)...))
+}
+
+func TestSQLiteSnapshot(t *testing.T) {
+	getSQLite(t, func(tt *TT) {
+		if tt.Initial(&InitialArgs{
+			Active: ActiveAuto,
+			Tables: []string{"users"},
+		}) {
+			return
+		}
+
+		_, err := tt.DB().Exec("update users set score = 2.5 where id = 2")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if tt.CheckQuery(&CheckQueryArgs{
+			Active:  ActiveAuto,
+			Name:    "score",
+			Queries: []*Query{NewQueryTable("users")},
+		}) {
+			return
+		}
+	})
+}
+
+func TestSQLiteSchema(t *testing.T) {
+	getSQLite(t, func(tt *TT) {
+		if tt.CheckSchema(&CheckSchemaArgs{
+			Active: ActiveAuto,
+			Tables: []string{"users", "orders"},
+		}) {
+			return
+		}
+
+		for _, q := range []string{
+			"alter table users add column email text",
+			"drop index idx_users_name",
+		} {
+			_, err := tt.DB().Exec(q)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+		}
+
+		expect, err := tt.LoadSchema("schema", []string{"users"})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		actual, err := tt.FetchSchema([]string{"users"})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		diff, same := CompareTable(expect[0], actual[0], false)
+		if same || !reflect.DeepEqual(diff, []string{"column email: unexpected", "index idx_users_name#1: missing"}) {
+			t.Errorf("unexpected diff: %v", diff)
+		}
+	})
+}
+
+func TestSQLiteTx(t *testing.T) {
+	getSQLite(t, func(tt *TT) {
+		count := func(e Executor) int {
+			var n int
+			err := e.QueryRow("select count(*) from users").Scan(&n)
+			if err != nil {
+				t.Error(err)
+			}
+			return n
+		}
+
+		t.Run("tx", func(t *testing.T) {
+			tx := NewTT(tt.DB(), t, WithDialect(SQLite), WithTx())
+
+			s, err := tx.NewSnapshotFromTables("users", []string{"users"})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			_, err = tx.Executor().Exec("delete from users")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			err = s.Apply(tx.Executor())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			_, err = tx.Executor().Exec("insert into users (id, name) values (3, 'carol')")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			if n := count(tx.Executor()); n != 3 {
+				t.Errorf("expect 3 users in tx, got %d", n)
+			}
+		})
+
+		if n := count(tt.DB()); n != 2 {
+			t.Errorf("expect 2 users after rollback, got %d", n)
+		}
+	})
+}
+
+func TestSQLiteForeignKeys(t *testing.T) {
+	getSQLite(t, func(tt *TT) {
+		for _, q := range []string{
+			"insert into orders values (1, 2, 9.99)",
+			"create table a (id integer primary key, b_id integer references b (id))",
+			"create table b (id integer primary key, a_id integer references a (id))",
+			"insert into a values (1, null)",
+			"insert into b values (1, 1)",
+			"update a set b_id = 1",
+		} {
+			_, err := tt.DB().Exec(q)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+		}
+
+		_, _, err := applyOrder(tt.DB(), SQLite, []string{"a", "b"})
+		if e, ok := err.(*ForeignKeyCycleError); !ok || !reflect.DeepEqual(e.Tables, []string{"a", "b"}) {
+			t.Errorf("expect cycle between a and b, got %v", err)
+		}
+
+		// orders references users from outside the snapshot
+		_, referenced, err := applyOrder(tt.DB(), SQLite, []string{"users"})
+		if err != nil || !referenced["users"] {
+			t.Errorf("expect users to be referenced, got %v %v", referenced, err)
+		}
+		_, referenced, err = applyOrder(tt.DB(), SQLite, []string{"orders"})
+		if err != nil || referenced["orders"] {
+			t.Errorf("expect orders not to be referenced, got %v %v", referenced, err)
+		}
+
+		for _, tables := range [][]string{{"users", "orders"}, {"a", "b"}} {
+			s, err := tt.NewSnapshotFromTables("fk", tables)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			err = s.Apply(tt.DB())
+			if err != nil {
+				t.Errorf("apply %v: %s", tables, err)
+				return
+			}
+		}
+
+		var n int
+		err = tt.DB().QueryRow("select count(*) from orders join users on users.id = orders.user_id").Scan(&n)
+		if err != nil || n != 1 {
+			t.Errorf("expect 1 order, got %d %v", n, err)
+		}
+
+		t.Run("tx", func(t *testing.T) {
+			tx := NewTT(tt.DB(), t, WithDialect(SQLite), WithTx())
+			s, err := tx.NewSnapshotFromTables("fk", []string{"a", "b"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = s.Apply(tx.Executor())
+			if e := (*ForeignKeyCycleError)(nil); !errors.As(err, &e) || !strings.Contains(err.Error(), "WithTx") {
+				t.Errorf("expect cycle error within a transaction, got %v", err)
+			}
+		})
+	})
+}
+
+func TestSQLiteFixture(t *testing.T) {
+	getSQLite(t, func(tt *TT) {
+		if tt.Initial(&InitialArgs{Active: ActiveApply}) {
+			return
+		}
+
+		rows, err := tt.DB().Query("select u.id, u.name, u.created is not null, o.amount from orders o join users u on u.id = o.user_id order by o.id")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer rows.Close()
+
+		var got []string
+		for rows.Next() {
+			var id int64
+			var name string
+			var created bool
+			var amount sql.NullString
+			err = rows.Scan(&id, &name, &created, &amount)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			got = append(got, fmt.Sprintf("%d %s %t %s", id, name, created, amount.String))
+		}
+
+		expect := []string{"2 dave false 9.9", "1 carol true "}
+		if !reflect.DeepEqual(got, expect) {
+			t.Errorf("unexpected applied rows: %q", got)
+		}
+	})
+}
+
+func TestSQLiteSubtestNaming(t *testing.T) {
+	root := t.TempDir()
+
+	t.Run("case 1/..", func(t *testing.T) {
+		getSQLite(t, func(tt *TT) {
+			s, err := tt.NewSnapshotFromTables("initial", []string{"users"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = s.Save(false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = os.Stat(filepath.Join(root, "TestSQLiteSubtestNaming", "case_1", "%2E.", "initial", ManifestFile))
+			if err != nil {
+				t.Error(err)
+			}
+
+			_, err = tt.LoadSnapshot("initial")
+			if err != nil {
+				t.Error(err)
+			}
+		}, WithRoot(root))
+	})
+}
+
+func TestSQLiteSharedFixtures(t *testing.T) {
+	getSQLite(t, func(tt *TT) {
+		if tt.Initial(&InitialArgs{Active: ActiveApply, Fixtures: []string{"base"}}) {
+			return
+		}
+
+		var users, orders int
+		var sum float64
+		err := tt.DB().QueryRow("select (select count(*) from users), count(*), sum(amount) from orders").Scan(&users, &orders, &sum)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if users != 2 || orders != 2 || sum != 15 {
+			t.Errorf("unexpected layers: %d users, %d orders of %v", users, orders, sum)
+		}
+	})
+}
+
+func TestSQLiteLegacyTimeTypes(t *testing.T) {
+	getSQLite(t, func(tt *TT) {
+		for _, q := range []string{
+			"create table events (id integer primary key, day date, at time)",
+			"insert into events values (1, '2018-12-01', null)",
+		} {
+			_, err := tt.DB().Exec(q)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		rows, err := tt.DB().Query("select * from events")
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := scan(rows, SQLite)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual.name = "events"
+
+		// as saved before DATE and TIME had their scan types, on a machine in
+		// another zone
+		col := func(name, databaseType, scanType string) string {
+			return fmt.Sprintf(`{"FullDatabaseType": "", "Name": %q, "HasNullable": true, "HasLength": false, "HasPrecisionScale": false,
+				"Nullable": true, "Length": 0, "DatabaseType": %q, "Precision": 0, "Scale": 0, "ScanType": %q}`, name, databaseType, scanType)
+		}
+		legacy := `{"version": 2, "name": "events", "isTable": false, "cols": [` +
+			col("id", "INTEGER", "sql.NullInt64") + `,` + col("day", "date", "mysql.NullTime") + `,` + col("at", "time", "mysql.NullTime") +
+			`], "rows": [{"id": 1, "day": "2018-12-01T00:00:00+08:00", "at": null}]}`
+		expect, err := Unmarshal([]byte(legacy))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if d := DiffResult(expect, actual); d != nil {
+			t.Errorf("unexpected diff: %s", (&SnapshotDiff{Results: []*ResultDiff{d}}).String())
+		}
+
+		_, err = tt.DB().Exec("update events set day = '2018-12-02'")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err = tt.DB().Query("select * from events")
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err = scan(rows, SQLite)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := DiffResult(expect, actual); d == nil || len(d.Changed) != 1 || d.Changed[0].Cells[0].Column != "day" {
+			t.Errorf("expect day diff, got %v", d)
+		}
+	})
+}
+
+func TestSQLiteUpdateSchema(t *testing.T) {
+	getSQLite(t, func(tt *TT) {
+		ls, err := tt.FetchSchema([]string{"users", "orders"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = tt.SaveSchema("schema", ls, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = tt.SaveSchema("schema", ls, false); !os.IsExist(err) {
+			t.Errorf("expect the schema to exist, got %v", err)
+		}
+
+		dir := tt.snapshotDir("schema")
+		old := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+		err = os.Chtimes(filepath.Join(dir, "orders"), old, old)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, "README"), []byte("kept"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = tt.DB().Exec("alter table users add column email text")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ls, err = tt.FetchSchema([]string{"users", "orders"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		summary, err := tt.UpdateSchema("schema", ls)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if summary.String() != "updated users" {
+			t.Errorf("unexpected summary: %s", summary)
+		}
+
+		if fi, err := os.Stat(filepath.Join(dir, "orders")); err != nil || !fi.ModTime().Equal(old) {
+			t.Errorf("orders should be untouched: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "README")); err != nil {
+			t.Errorf("unknown files should be kept: %v", err)
+		}
+	}, WithRoot(t.TempDir()))
+}
+
+func TestSQLiteForeignKeySchema(t *testing.T) {
+	getSQLite(t, func(tt *TT) {
+		rebuild := func(def string) *Table {
+			for _, q := range []string{
+				"drop table if exists shipments",
+				"create table shipments (id integer primary key, order_id integer, user_id integer, " + def + ")",
+			} {
+				_, err := tt.DB().Exec(q)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			tab, err := SQLite.Table(tt.DB(), "shipments")
+			if err != nil {
+				t.Fatal(err)
+			}
+			return tab
+		}
+
+		expect := rebuild("foreign key (order_id) references orders (id), foreign key (user_id) references users (id) on delete cascade")
+
+		// the same foreign keys declared in another order get other ids
+		actual := rebuild("foreign key (user_id) references users (id) on delete cascade, foreign key (order_id) references orders (id)")
+		if diff, same := CompareTable(expect, actual, false); !same {
+			t.Errorf("unexpected diff %v", diff)
+		}
+
+		actual = rebuild("foreign key (user_id) references users (id)")
+		diff, _ := CompareTable(expect, actual, false)
+		if !reflect.DeepEqual(diff, []string{"foreign key user_id->users.id: DeleteRule", "foreign key order_id->orders.id: missing"}) {
+			t.Errorf("expect a dropped and a changed foreign key, got %v", diff)
+		}
+	})
+}
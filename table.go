@@ -4,6 +4,9 @@ import (
 	"database/sql"
 	"errors"
 	"github.com/go-sql-driver/mysql"
+	"reflect"
+	"strconv"
+	"strings"
 )
 
 type ColumnDB struct {
@@ -83,10 +86,186 @@ type TableDB struct {
 	TableComment   string         `db:"TABLE_COMMENT"`
 }
 
-//type Table struct {
-//	T TableDB
-//	C []ColumnDB
-//}
+// selectAll scans every row of the query into dest, a pointer to a slice of
+// structs whose fields are tagged with their column names.
+func selectAll(db Executor, dest interface{}, from string, where string, args ...interface{}) error {
+	slice := reflect.ValueOf(dest).Elem()
+	typ := slice.Type().Elem()
+	isPtr := typ.Kind() == reflect.Ptr
+	if isPtr {
+		typ = typ.Elem()
+	}
+
+	cols := make([]string, typ.NumField())
+	for i := range cols {
+		cols[i] = typ.Field(i).Tag.Get("db")
+	}
+
+	q := "select " + strings.Join(cols, ",") + " from " + from
+	if where != "" {
+		q += " where " + where
+	}
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		v := reflect.New(typ)
+		fields := make([]interface{}, len(cols))
+		for i := range fields {
+			fields[i] = v.Elem().Field(i).Addr().Interface()
+		}
+
+		err = rows.Scan(fields...)
+		if err != nil {
+			return err
+		}
+
+		if isPtr {
+			slice.Set(reflect.Append(slice, v))
+		} else {
+			slice.Set(reflect.Append(slice, v.Elem()))
+		}
+	}
+
+	return rows.Err()
+}
+
+func FetchIndexes(db Executor, table string) ([]*IndexDB, error) {
+	var ls []*IndexDB
+	err := selectAll(db, &ls, "information_schema.STATISTICS",
+		"TABLE_SCHEMA = database() and TABLE_NAME = ? order by INDEX_NAME, SEQ_IN_INDEX", table)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range ls {
+		v.Cardinality = sql.NullInt64{}
+	}
+	return ls, nil
+}
+
+func FetchForeignKeys(db Executor, table string) ([]*ForeignKeyDB, error) {
+	var ls []*ForeignKeyDB
+	err := selectAll(db, &ls,
+		"information_schema.KEY_COLUMN_USAGE join information_schema.REFERENTIAL_CONSTRAINTS "+
+			"using (CONSTRAINT_CATALOG, CONSTRAINT_SCHEMA, CONSTRAINT_NAME, TABLE_NAME, REFERENCED_TABLE_NAME)",
+		"CONSTRAINT_SCHEMA = database() and TABLE_NAME = ? order by CONSTRAINT_NAME, ORDINAL_POSITION", table)
+	if err != nil {
+		return nil, err
+	}
+	return ls, nil
+}
+
+// FetchReferencingTables returns the other tables of the current database
+// with foreign keys to table.
+func FetchReferencingTables(db Executor, table string) ([]string, error) {
+	return queryStrings(db, "select distinct TABLE_NAME from information_schema.REFERENTIAL_CONSTRAINTS "+
+		"where CONSTRAINT_SCHEMA = database() and REFERENCED_TABLE_NAME = ? and TABLE_NAME <> ? order by TABLE_NAME", table, table)
+}
+
+// queryStrings returns the first column of the rows of the query.
+func queryStrings(db Executor, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ls []string
+	for rows.Next() {
+		var s string
+		err = rows.Scan(&s)
+		if err != nil {
+			return nil, err
+		}
+		ls = append(ls, s)
+	}
+	return ls, rows.Err()
+}
+
+func FetchColumns(db Executor, table string) ([]*ColumnDB, error) {
+	var cols []*ColumnDB
+	err := selectAll(db, &cols, "information_schema.COLUMNS",
+		"TABLE_SCHEMA = database() and TABLE_NAME = ? order by ORDINAL_POSITION", table)
+	if err != nil {
+		return nil, err
+	}
+	if len(cols) == 0 {
+		return nil, errors.New("table not found: " + table)
+	}
+	return cols, nil
+}
+
+// OrderColumns picks the columns giving a deterministic row order: the
+// primary key, a not null unique key, or all columns.
+func OrderColumns(cols []*ColumnDB) []string {
+	var keys []string
+	for _, c := range cols {
+		if c.ColumnKey == "PRI" {
+			keys = append(keys, c.ColumnName)
+		}
+	}
+	if len(keys) > 0 {
+		return keys
+	}
+
+	for _, c := range cols {
+		if c.ColumnKey == "UNI" && !c.IsNullable {
+			return []string{c.ColumnName}
+		}
+	}
+
+	for _, c := range cols {
+		keys = append(keys, c.ColumnName)
+	}
+	return keys
+}
+
+type IndexDB struct {
+	TableCatalog string         `db:"TABLE_CATALOG"`
+	TableSchema  string         `db:"TABLE_SCHEMA"`
+	TableName    string         `db:"TABLE_NAME"`
+	NonUnique    int            `db:"NON_UNIQUE"`
+	IndexSchema  string         `db:"INDEX_SCHEMA"`
+	IndexName    string         `db:"INDEX_NAME"`
+	SeqInIndex   int            `db:"SEQ_IN_INDEX"`
+	ColumnName   sql.NullString `db:"COLUMN_NAME"`
+	Collation    sql.NullString `db:"COLLATION"`
+	Cardinality  sql.NullInt64  `db:"CARDINALITY"`
+	SubPart      sql.NullInt64  `db:"SUB_PART"`
+	Packed       sql.NullString `db:"PACKED"`
+	Nullable     string         `db:"NULLABLE"`
+	IndexType    string         `db:"INDEX_TYPE"`
+	Comment      sql.NullString `db:"COMMENT"`
+	IndexComment string         `db:"INDEX_COMMENT"`
+}
+
+// ForeignKeyDB is a row of KEY_COLUMN_USAGE joined with the
+// REFERENTIAL_CONSTRAINTS of its constraint. ConstraintName is empty on
+// SQLite, which does not report it.
+type ForeignKeyDB struct {
+	ConstraintSchema      string `db:"CONSTRAINT_SCHEMA"`
+	ConstraintName        string `db:"CONSTRAINT_NAME"`
+	TableName             string `db:"TABLE_NAME"`
+	ColumnName            string `db:"COLUMN_NAME"`
+	OrdinalPosition       int    `db:"ORDINAL_POSITION"`
+	ReferencedTableSchema string `db:"REFERENCED_TABLE_SCHEMA"`
+	ReferencedTableName   string `db:"REFERENCED_TABLE_NAME"`
+	ReferencedColumnName  string `db:"REFERENCED_COLUMN_NAME"`
+	MatchOption           string `db:"MATCH_OPTION"`
+	UpdateRule            string `db:"UPDATE_RULE"`
+	DeleteRule            string `db:"DELETE_RULE"`
+}
+
+type Table struct {
+	T TableDB
+	C []*ColumnDB
+	I []*IndexDB
+	F []*ForeignKeyDB
+}
 
 func CompareColumn(a, b *ColumnDB, unsafe bool) (diff []string, same bool) {
 	same = true
@@ -156,98 +335,249 @@ func CompareColumn(a, b *ColumnDB, unsafe bool) (diff []string, same bool) {
 	return
 }
 
-//func CompareTable(a, b *Table, unsafe bool) (diff string, same bool) {
-//	if a.T.TableCatalog != b.T.TableCatalog {
-//		return "TableCatalog", false
-//	}
-//	//if a.T.TableSchema != b.T.TableSchema {
-//	//	return false
-//	//}
-//	if a.T.TableName != b.T.TableName {
-//		return "TableName", false
-//	}
-//	if a.T.TableType != b.T.TableType {
-//		return "TableType", false
-//	}
-//	if a.T.Engine != b.T.Engine {
-//		return "Engine", false
-//	}
-//	if a.T.Version != b.T.Version {
-//		return "Version", false
-//	}
-//	if a.T.RowFormat != b.T.RowFormat {
-//		return "RowFormat", false
-//	}
-//	//if a.T.TableRows != b.T.TableRows {
-//	//	return false
-//	//}
-//	//if a.T.AvgRowLength != b.T.AvgRowLength {
-//	//	return false
-//	//}
-//	//if a.T.DataLength != b.T.DataLength {
-//	//	return false
-//	//}
-//	//if a.T.MaxDataLength != b.T.MaxDataLength {
-//	//	return false
-//	//}
-//	//if a.T.IndexLength != b.T.IndexLength {
-//	//	return false
-//	//}
-//	if a.T.DataFree != b.T.DataFree {
-//		return "DataFree", false
-//	}
-//	if !unsafe && a.T.AutoIncrement != b.T.AutoIncrement {
-//		return "AutoIncrement", false
-//	}
-//	//if a.T.CreateTime != b.T.CreateTime {
-//	//	return false
-//	//}
-//	//if a.T.UpdateTime != b.T.UpdateTime {
-//	//	return false
-//	//}
-//	//if a.T.CheckTime != b.T.CheckTime {
-//	//	return false
-//	//}
-//	if a.T.TableCollation != b.T.TableCollation {
-//		return "TableCollation", false
-//	}
-//	//if a.T.Checksum != b.T.Checksum {
-//	//	return "Checksum", false
-//	//}
-//	//if a.T.CreateOptions != b.T.CreateOptions {
-//	//	return "CreateOptions", false
-//	//}
-//	if !unsafe && a.T.TableComment != b.T.TableComment {
-//		return "TableComment", false
-//	}
-//	if len(a.C) != len(b.C) {
-//		return "len", false
-//	}
-//	for i := range a.C {
-//		diff, same := CompareColumn(&a.C[i], &b.C[i], unsafe)
-//		if !same {
-//			return strings.Join(diff, ","), false
-//		}
-//	}
-//
-//	return "", true
-//}
-//
-//func FetchTable(db *sqlx.TT, schema string, name string) (*Table, error) {
-//	tab := Table{}
-//
-//	err := db.Get(&tab.T, "select * from information_schema.TABLES where TABLE_SCHEMA = ? and TABLE_NAME = ?", schema, name)
-//	if err != nil {
-//		return nil, err
-//	}
-//
-//	err = db.Select(&tab.C, "select * from information_schema.COLUMNS where TABLE_SCHEMA = ? and TABLE_NAME = ?", schema, name)
-//	if err != nil {
-//		return nil, err
-//	}
-//
-//	return &tab, nil
-//}
+func CompareTable(a, b *Table, unsafe bool) (diff []string, same bool) {
+	same = true
+	if a.T.TableCatalog != b.T.TableCatalog {
+		diff, same = append(diff, "TableCatalog"), false
+	}
+	//if a.T.TableSchema != b.T.TableSchema {
+	//	diff, same = append(diff, "TableSchema"), false
+	//}
+	if a.T.TableName != b.T.TableName {
+		diff, same = append(diff, "TableName"), false
+	}
+	if a.T.TableType != b.T.TableType {
+		diff, same = append(diff, "TableType"), false
+	}
+	if a.T.Engine != b.T.Engine {
+		diff, same = append(diff, "Engine"), false
+	}
+	if a.T.Version != b.T.Version {
+		diff, same = append(diff, "Version"), false
+	}
+	if a.T.RowFormat != b.T.RowFormat {
+		diff, same = append(diff, "RowFormat"), false
+	}
+	//if a.T.DataFree != b.T.DataFree {
+	//	diff, same = append(diff, "DataFree"), false
+	//}
+	// AutoIncrement grows with the data, and was recorded by older versions
+	//if a.T.AutoIncrement != b.T.AutoIncrement {
+	//	diff, same = append(diff, "AutoIncrement"), false
+	//}
+	if a.T.TableCollation != b.T.TableCollation {
+		diff, same = append(diff, "TableCollation"), false
+	}
+	//if a.T.CreateOptions != b.T.CreateOptions {
+	//	diff, same = append(diff, "CreateOptions"), false
+	//}
+	if !unsafe && a.T.TableComment != b.T.TableComment {
+		diff, same = append(diff, "TableComment"), false
+	}
+
+	bc := make(map[string]*ColumnDB, len(b.C))
+	for _, c := range b.C {
+		bc[c.ColumnName] = c
+	}
+
+	for _, c := range a.C {
+		cb, ok := bc[c.ColumnName]
+		if !ok {
+			diff, same = append(diff, "column "+c.ColumnName+": missing"), false
+			continue
+		}
+		delete(bc, c.ColumnName)
+
+		if d, ok := CompareColumn(c, cb, unsafe); !ok {
+			diff, same = append(diff, "column "+c.ColumnName+": "+strings.Join(d, ",")), false
+		}
+	}
+
+	for _, c := range b.C {
+		if _, ok := bc[c.ColumnName]; ok {
+			diff, same = append(diff, "column "+c.ColumnName+": unexpected"), false
+		}
+	}
+
+	bi := make(map[string]*IndexDB, len(b.I))
+	for _, v := range b.I {
+		bi[indexKey(v)] = v
+	}
+
+	for _, v := range a.I {
+		k := indexKey(v)
+		vb, ok := bi[k]
+		if !ok {
+			diff, same = append(diff, "index "+k+": missing"), false
+			continue
+		}
+		delete(bi, k)
+
+		if d, ok := CompareIndex(v, vb, unsafe); !ok {
+			diff, same = append(diff, "index "+k+": "+strings.Join(d, ",")), false
+		}
+	}
+
+	for _, v := range b.I {
+		if k := indexKey(v); bi[k] != nil {
+			diff, same = append(diff, "index "+k+": unexpected"), false
+		}
+	}
+
+	bf := make(map[string]*ForeignKeyDB, len(b.F))
+	for _, v := range b.F {
+		bf[foreignKeyKey(v)] = v
+	}
+
+	for _, v := range a.F {
+		k := foreignKeyKey(v)
+		vb, ok := bf[k]
+		if !ok {
+			diff, same = append(diff, "foreign key "+k+": missing"), false
+			continue
+		}
+		delete(bf, k)
+
+		if d, ok := CompareForeignKey(v, vb); !ok {
+			diff, same = append(diff, "foreign key "+k+": "+strings.Join(d, ",")), false
+		}
+	}
+
+	for _, v := range b.F {
+		if k := foreignKeyKey(v); bf[k] != nil {
+			diff, same = append(diff, "foreign key "+k+": unexpected"), false
+		}
+	}
+
+	return
+}
+
+func indexKey(v *IndexDB) string {
+	return v.IndexName + "#" + strconv.Itoa(v.SeqInIndex)
+}
+
+// foreignKeyKey identifies a column of a foreign key by the column it
+// references, constraint names being unknown on SQLite.
+func foreignKeyKey(v *ForeignKeyDB) string {
+	return v.ColumnName + "->" + v.ReferencedTableName + "." + v.ReferencedColumnName
+}
+
+func CompareIndex(a, b *IndexDB, unsafe bool) (diff []string, same bool) {
+	same = true
+	if a.NonUnique != b.NonUnique {
+		diff, same = append(diff, "NonUnique"), false
+	}
+	if a.IndexName != b.IndexName {
+		diff, same = append(diff, "IndexName"), false
+	}
+	if a.SeqInIndex != b.SeqInIndex {
+		diff, same = append(diff, "SeqInIndex"), false
+	}
+	if a.ColumnName != b.ColumnName {
+		diff, same = append(diff, "ColumnName"), false
+	}
+	if a.Collation != b.Collation {
+		diff, same = append(diff, "Collation"), false
+	}
+	//if a.Cardinality != b.Cardinality {
+	//	diff, same = append(diff, "Cardinality"), false
+	//}
+	if a.SubPart != b.SubPart {
+		diff, same = append(diff, "SubPart"), false
+	}
+	if a.Packed != b.Packed {
+		diff, same = append(diff, "Packed"), false
+	}
+	if a.Nullable != b.Nullable {
+		diff, same = append(diff, "Nullable"), false
+	}
+	if a.IndexType != b.IndexType {
+		diff, same = append(diff, "IndexType"), false
+	}
+	if a.Comment != b.Comment {
+		diff, same = append(diff, "Comment"), false
+	}
+	if !unsafe && a.IndexComment != b.IndexComment {
+		diff, same = append(diff, "IndexComment"), false
+	}
+	return
+}
+
+func CompareForeignKey(a, b *ForeignKeyDB) (diff []string, same bool) {
+	same = true
+	// unknown on SQLite, and synthesized by older versions
+	if a.ConstraintName != "" && b.ConstraintName != "" && a.ConstraintName != b.ConstraintName {
+		diff, same = append(diff, "ConstraintName"), false
+	}
+	if a.ColumnName != b.ColumnName {
+		diff, same = append(diff, "ColumnName"), false
+	}
+	if a.OrdinalPosition != b.OrdinalPosition {
+		diff, same = append(diff, "OrdinalPosition"), false
+	}
+	//if a.ReferencedTableSchema != b.ReferencedTableSchema {
+	//	diff, same = append(diff, "ReferencedTableSchema"), false
+	//}
+	if a.ReferencedTableName != b.ReferencedTableName {
+		diff, same = append(diff, "ReferencedTableName"), false
+	}
+	if a.ReferencedColumnName != b.ReferencedColumnName {
+		diff, same = append(diff, "ReferencedColumnName"), false
+	}
+	if a.MatchOption != b.MatchOption {
+		diff, same = append(diff, "MatchOption"), false
+	}
+	if a.UpdateRule != b.UpdateRule {
+		diff, same = append(diff, "UpdateRule"), false
+	}
+	if a.DeleteRule != b.DeleteRule {
+		diff, same = append(diff, "DeleteRule"), false
+	}
+	return
+}
+
+// FetchTable loads the definition of a table in the current database. The
+// statistics that change with the data are left empty.
+func FetchTable(db Executor, name string) (*Table, error) {
+	var ts []*TableDB
+	err := selectAll(db, &ts, "information_schema.TABLES", "TABLE_SCHEMA = database() and TABLE_NAME = ?", name)
+	if err != nil {
+		return nil, err
+	}
+	if len(ts) == 0 {
+		return nil, errors.New("table not found: " + name)
+	}
+
+	tab := &Table{T: *ts[0]}
+	tab.T.TableRows = sql.NullInt64{}
+	tab.T.AvgRowLength = sql.NullInt64{}
+	tab.T.DataLength = sql.NullInt64{}
+	tab.T.MaxDataLength = sql.NullInt64{}
+	tab.T.IndexLength = sql.NullInt64{}
+	tab.T.DataFree = sql.NullInt64{}
+	tab.T.AutoIncrement = sql.NullInt64{}
+	tab.T.CreateTime = mysql.NullTime{}
+	tab.T.UpdateTime = mysql.NullTime{}
+	tab.T.CheckTime = mysql.NullTime{}
+	tab.T.Checksum = sql.NullInt64{}
+
+	tab.C, err = FetchColumns(db, name)
+	if err != nil {
+		return nil, err
+	}
+
+	tab.I, err = FetchIndexes(db, name)
+	if err != nil {
+		return nil, err
+	}
+
+	tab.F, err = FetchForeignKeys(db, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return tab, nil
+}
 
 //func SliceScan(r *sql.Result) ([]Value, error) {
 //	columns, err := r.ColumnTypes()
@@ -2,9 +2,11 @@ package dbtesting
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	_ "github.com/go-sql-driver/mysql"
 	"os"
+	"reflect"
 	"testing"
 )
 
@@ -90,3 +92,158 @@ func TestCompareColumn(t *testing.T) {
 	//fmt.Println(fi.Name(), fi.IsDir())
 
 }
+
+func TestCompareTable(t *testing.T) {
+	tab := func(autoIncrement int64) *Table {
+		return &Table{
+			T: TableDB{TableName: "users", Engine: sql.NullString{String: "InnoDB", Valid: true}, AutoIncrement: sql.NullInt64{Int64: autoIncrement, Valid: true}},
+			C: []*ColumnDB{{ColumnName: "id", ColumnType: "int(11)"}},
+		}
+	}
+
+	// rows inserted between record and check
+	if diff, same := CompareTable(tab(1), tab(42), false); !same {
+		t.Errorf("expect the data to be ignored, got %v", diff)
+	}
+
+	b := tab(1)
+	b.C[0].ColumnType = "bigint(20)"
+	if _, same := CompareTable(tab(1), b, false); same {
+		t.Error("expect a column diff")
+	}
+}
+
+func TestCompareIndex(t *testing.T) {
+	idx := func(seq int, col string) *IndexDB {
+		return &IndexDB{TableName: "users", NonUnique: 1, IndexName: "idx_name_age", SeqInIndex: seq, ColumnName: sql.NullString{String: col, Valid: true}, IndexType: "BTREE"}
+	}
+
+	a, b := idx(1, "name"), idx(1, "name")
+	b.Cardinality = sql.NullInt64{Int64: 42, Valid: true}
+	if diff, same := CompareIndex(a, b, false); !same {
+		t.Errorf("expect the cardinality to be ignored, got %v", diff)
+	}
+
+	// the columns of a composite index swapped
+	b = idx(1, "age")
+	b.NonUnique = 0
+	if diff, _ := CompareIndex(a, b, false); !reflect.DeepEqual(diff, []string{"NonUnique", "ColumnName"}) {
+		t.Errorf("unexpected diff %v", diff)
+	}
+
+	b = idx(1, "name")
+	b.IndexComment = "by name"
+	if diff, _ := CompareIndex(a, b, false); !reflect.DeepEqual(diff, []string{"IndexComment"}) {
+		t.Errorf("unexpected diff %v", diff)
+	}
+	if _, same := CompareIndex(a, b, true); !same {
+		t.Error("expect the comment to be ignored when unsafe")
+	}
+}
+
+func TestCompareForeignKey(t *testing.T) {
+	fk := func(name string) *ForeignKeyDB {
+		return &ForeignKeyDB{ConstraintName: name, TableName: "orders", ColumnName: "user_id", OrdinalPosition: 1,
+			ReferencedTableName: "users", ReferencedColumnName: "id", MatchOption: "NONE", UpdateRule: "RESTRICT", DeleteRule: "RESTRICT"}
+	}
+
+	if diff, same := CompareForeignKey(fk("fk_user"), fk("")); !same {
+		t.Errorf("expect unknown names to match, got %v", diff)
+	}
+	if diff, _ := CompareForeignKey(fk("fk_user"), fk("fk_owner")); !reflect.DeepEqual(diff, []string{"ConstraintName"}) {
+		t.Errorf("unexpected diff %v", diff)
+	}
+
+	b := fk("fk_user")
+	b.DeleteRule, b.UpdateRule = "CASCADE", "CASCADE"
+	if diff, _ := CompareForeignKey(fk("fk_user"), b); !reflect.DeepEqual(diff, []string{"UpdateRule", "DeleteRule"}) {
+		t.Errorf("unexpected diff %v", diff)
+	}
+
+	tab := func(fks ...*ForeignKeyDB) *Table {
+		return &Table{T: TableDB{TableName: "orders"}, F: fks}
+	}
+	if diff, _ := CompareTable(tab(fk("fk_user")), tab(b), false); !reflect.DeepEqual(diff, []string{"foreign key user_id->users.id: UpdateRule,DeleteRule"}) {
+		t.Errorf("expect a changed foreign key, got %v", diff)
+	}
+	if diff, _ := CompareTable(tab(fk("fk_user")), tab(), false); !reflect.DeepEqual(diff, []string{"foreign key user_id->users.id: missing"}) {
+		t.Errorf("expect a dropped foreign key, got %v", diff)
+	}
+}
+
+func TestOrderColumns(t *testing.T) {
+	cases := []struct {
+		cols   []*ColumnDB
+		expect []string
+	}{
+		{[]*ColumnDB{{ColumnName: "a", ColumnKey: "PRI"}, {ColumnName: "b"}, {ColumnName: "c", ColumnKey: "PRI"}}, []string{"a", "c"}},
+		{[]*ColumnDB{{ColumnName: "a", ColumnKey: "UNI", IsNullable: true}, {ColumnName: "b", ColumnKey: "UNI"}}, []string{"b"}},
+		{[]*ColumnDB{{ColumnName: "a", ColumnKey: "MUL"}, {ColumnName: "b"}}, []string{"a", "b"}},
+	}
+
+	for _, c := range cases {
+		if o := OrderColumns(c.cols); !reflect.DeepEqual(o, c.expect) {
+			t.Errorf("expect %v, got %v", c.expect, o)
+		}
+	}
+}
+
+func TestQueryDrift(t *testing.T) {
+	q := NewQuery("max", "select max(id) from t")
+	q.RegisterComparator("id", TimeEqual)
+	q.RegisterNamedComparator("name", "Regexp(^a)", Regexp("^a"))
+	q.RegisterComparator("score", func(_, actual interface{}) (string, bool) {
+		n, ok := numberOf(actual)
+		return "expect a positive score", ok && n > 0
+	})
+
+	r := newTestResult("max", []string{"id", "name", "score"})
+	r.query = q
+
+	data, err := Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorded, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.checkDrift(recorded); err != nil {
+		t.Errorf("expect no drift, got %v", err)
+	}
+
+	// closures are named by their function, whatever their number in it
+	q.RegisterComparator("score", func(_, actual interface{}) (string, bool) {
+		n, ok := numberOf(actual)
+		return "expect a score of at least 1", ok && n >= 1
+	})
+	if err := q.checkDrift(recorded); err != nil {
+		t.Errorf("expect no drift for another closure, got %v", err)
+	}
+
+	q.RegisterNamedComparator("name", "Regexp(^b)", Regexp("^b"))
+	if err := q.checkDrift(recorded); !errors.Is(err, ErrQueryChanged) {
+		t.Errorf("expect drift of the comparator arguments, got %v", err)
+	}
+
+	q.RegisterNamedComparator("name", "Regexp(^a)", Ignore())
+	if err := q.checkDrift(recorded); !errors.Is(err, ErrQueryChanged) {
+		t.Errorf("expect drift of the comparator function, got %v", err)
+	}
+	q.RegisterNamedComparator("name", "Regexp(^a)", Regexp("^a"))
+
+	q.RegisterComparator("id", TimeShouldAfter)
+	if err := q.checkDrift(recorded); !errors.Is(err, ErrQueryChanged) {
+		t.Errorf("expect comparator drift, got %v", err)
+	}
+
+	q2 := NewQuery("max", "select min(id) from t")
+	q2.RegisterComparator("id", TimeEqual)
+	q2.RegisterNamedComparator("name", "Regexp(^a)", Regexp("^a"))
+	q2.RegisterComparator("score", Ignore())
+	if err := q2.checkDrift(recorded); !errors.Is(err, ErrQueryChanged) {
+		t.Errorf("expect query drift, got %v", err)
+	}
+}
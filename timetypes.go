@@ -0,0 +1,422 @@
+package dbtesting
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The zones of time columns. Values of both are saved in UTC, but TIMESTAMP
+// values are instants, converted to UTC, while DATETIME and DATE ones have no
+// zone and keep their wall clock whatever the location of the driver.
+const (
+	zoneUTC  = "UTC"
+	zoneNone = "none"
+)
+
+// wallClock is the layout of the times of zoneless columns when inserted.
+const wallClock = "2006-01-02 15:04:05.999999999"
+
+// timeKind gives the type of time columns, "" for other columns.
+func timeKind(databaseType string) string {
+	typ := strings.ToUpper(databaseType)
+	if i := strings.IndexByte(typ, '('); i >= 0 {
+		typ = typ[:i]
+	}
+	switch typ {
+	case "DATETIME", "TIMESTAMP", "DATE", "TIME", "YEAR":
+		return typ
+	default:
+		return ""
+	}
+}
+
+// setTimeSemantics derives the zone and the fractional seconds of time
+// columns from their database type, unless already known.
+func (c *ColType) setTimeSemantics() {
+	kind := timeKind(c.databaseType)
+	if c.zone == "" {
+		switch kind {
+		case "TIMESTAMP":
+			c.zone = zoneUTC
+		case "DATETIME", "DATE":
+			c.zone = zoneNone
+		}
+	}
+
+	if !c.hasDatetimePrecision && c.hasPrecisionScale {
+		switch kind {
+		case "DATETIME", "TIMESTAMP", "TIME":
+			c.datetimePrecision, c.hasDatetimePrecision = c.scale, true
+		}
+	}
+}
+
+// fspUnit gives the unit of the fractional seconds of the column, 0 when
+// unknown.
+func (c *ColType) fspUnit() time.Duration {
+	if !c.hasDatetimePrecision || c.datetimePrecision < 0 || c.datetimePrecision > 9 {
+		return 0
+	}
+	unit := time.Second
+	for i := int64(0); i < c.datetimePrecision; i++ {
+		unit /= 10
+	}
+	return unit
+}
+
+func (c *ColType) normalizeTime(t time.Time) time.Time {
+	if c.zone == zoneNone {
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	} else {
+		t = t.UTC()
+	}
+	if unit := c.fspUnit(); unit > 0 {
+		t = t.Round(unit)
+	}
+	return t
+}
+
+// normalizeCell brings times to UTC, rounded to the precision of their
+// column, so that values scanned with any driver location, or saved by older
+// versions, compare equal.
+func normalizeCell(v interface{}, col *ColType) interface{} {
+	switch vv := v.(type) {
+	case time.Time:
+		return col.normalizeTime(vv)
+	case mysql.NullTime:
+		if vv.Valid {
+			vv.Time = col.normalizeTime(vv.Time)
+		}
+		return vv
+	case sql.NullTime:
+		if vv.Valid {
+			vv.Time = col.normalizeTime(vv.Time)
+		}
+		return vv
+	case NullDuration:
+		if unit := col.fspUnit(); vv.Valid && unit > 0 {
+			vv.Duration = vv.Duration.Round(unit)
+		}
+		return vv
+	default:
+		return v
+	}
+}
+
+// encodeCell saves the times of columns of known precision with exactly as
+// many fractional digits.
+func encodeCell(v interface{}, col *ColType) (interface{}, error) {
+	if col.hasDatetimePrecision {
+		fsp := int(col.datetimePrecision)
+		switch vv := v.(type) {
+		case time.Time:
+			return formatTime(vv, fsp), nil
+		case mysql.NullTime:
+			if !vv.Valid {
+				return nil, nil
+			}
+			return formatTime(vv.Time, fsp), nil
+		case sql.NullTime:
+			if !vv.Valid {
+				return nil, nil
+			}
+			return formatTime(vv.Time, fsp), nil
+		case NullDuration:
+			if !vv.Valid {
+				return nil, nil
+			}
+			return formatDuration(vv.Duration, fsp), nil
+		}
+	}
+	return encodeValue(v, col.scanType)
+}
+
+func decodeCell(v interface{}, col *ColType) (interface{}, error) {
+	dv, err := decodeValue(v, col.scanType)
+	if err != nil {
+		return nil, err
+	}
+	return normalizeCell(dv, col), nil
+}
+
+// insertValue passes the times of zoneless columns as their wall clock, which
+// the driver would otherwise convert to its location.
+func insertValue(v interface{}, col *ColType) interface{} {
+	if col.zone != zoneNone {
+		return v
+	}
+	switch vv := v.(type) {
+	case time.Time:
+		return vv.Format(wallClock)
+	case mysql.NullTime:
+		if !vv.Valid {
+			return nil
+		}
+		return vv.Time.Format(wallClock)
+	case sql.NullTime:
+		if !vv.Valid {
+			return nil
+		}
+		return vv.Time.Format(wallClock)
+	default:
+		return v
+	}
+}
+
+func formatTime(t time.Time, fsp int) string {
+	layout := "2006-01-02T15:04:05"
+	if fsp > 0 {
+		layout += "." + strings.Repeat("0", fsp)
+	}
+	return t.Format(layout + "Z07:00")
+}
+
+// NullDate is the scan type of DATE columns, a day without zone, saved as
+// "2006-01-02". The zero date of MySQL is valid, with all fields zero.
+type NullDate struct {
+	Year  int
+	Month time.Month
+	Day   int
+	Valid bool
+}
+
+func (d *NullDate) Scan(v interface{}) error {
+	switch vv := v.(type) {
+	case nil:
+		*d = NullDate{}
+	case time.Time:
+		*d = NullDate{Year: vv.Year(), Month: vv.Month(), Day: vv.Day(), Valid: true}
+	case []byte:
+		return d.parse(string(vv))
+	case string:
+		return d.parse(vv)
+	default:
+		return fmt.Errorf("unsupported DATE value: %T", v)
+	}
+	return nil
+}
+
+// parse takes the date at the start of s, so that the RFC 3339 times of
+// older snapshots keep their day.
+func (d *NullDate) parse(s string) error {
+	if len(s) < 10 || s[4] != '-' || s[7] != '-' {
+		return fmt.Errorf("invalid date %q", s)
+	}
+	y, err1 := strconv.Atoi(s[:4])
+	m, err2 := strconv.Atoi(s[5:7])
+	day, err3 := strconv.Atoi(s[8:10])
+	if err1 != nil || err2 != nil || err3 != nil || m > 12 || day > 31 {
+		return fmt.Errorf("invalid date %q", s)
+	}
+	*d = NullDate{Year: y, Month: time.Month(m), Day: day, Valid: true}
+	return nil
+}
+
+func (d NullDate) Value() (driver.Value, error) {
+	if !d.Valid {
+		return nil, nil
+	}
+	return d.String(), nil
+}
+
+func (d NullDate) String() string {
+	if !d.Valid {
+		return "NULL"
+	}
+	return fmt.Sprintf("%04d-%02d-%02d", d.Year, d.Month, d.Day)
+}
+
+// NullDuration is the scan type of TIME columns, which hold durations of
+// either sign rather than times of day, saved as "-838:59:59.000000".
+type NullDuration struct {
+	Duration time.Duration
+	Valid    bool
+}
+
+func (d *NullDuration) Scan(v interface{}) error {
+	switch vv := v.(type) {
+	case nil:
+		*d = NullDuration{}
+	case time.Time:
+		// the time of day, as scanned by older versions
+		*d = NullDuration{Duration: vv.Sub(time.Date(vv.Year(), vv.Month(), vv.Day(), 0, 0, 0, 0, vv.Location())), Valid: true}
+	case []byte:
+		return d.parse(string(vv))
+	case string:
+		return d.parse(vv)
+	default:
+		return fmt.Errorf("unsupported TIME value: %T", v)
+	}
+	return nil
+}
+
+func (d *NullDuration) parse(s string) error {
+	neg := strings.HasPrefix(s, "-")
+	parts := strings.Split(strings.TrimPrefix(s, "-"), ":")
+	if len(parts) != 3 {
+		return fmt.Errorf("invalid time %q", s)
+	}
+
+	sec, frac := parts[2], "0"
+	if i := strings.IndexByte(sec, '.'); i >= 0 {
+		sec, frac = sec[:i], (sec[i+1:] + "000000000")[:9]
+	}
+
+	h, err1 := strconv.ParseUint(parts[0], 10, 32)
+	m, err2 := strconv.ParseUint(parts[1], 10, 8)
+	sc, err3 := strconv.ParseUint(sec, 10, 8)
+	ns, err4 := strconv.ParseUint(frac, 10, 32)
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil || m > 59 || sc > 59 {
+		return fmt.Errorf("invalid time %q", s)
+	}
+
+	dur := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(sc)*time.Second + time.Duration(ns)
+	if neg {
+		dur = -dur
+	}
+	*d = NullDuration{Duration: dur, Valid: true}
+	return nil
+}
+
+func (d NullDuration) Value() (driver.Value, error) {
+	if !d.Valid {
+		return nil, nil
+	}
+	return formatDuration(d.Duration, -1), nil
+}
+
+func (d NullDuration) String() string {
+	if !d.Valid {
+		return "NULL"
+	}
+	return formatDuration(d.Duration, -1)
+}
+
+// formatDuration formats d as a TIME value with fsp fractional digits, or as
+// few as needed when negative.
+func formatDuration(d time.Duration, fsp int) string {
+	b := &strings.Builder{}
+	if d < 0 {
+		b.WriteByte('-')
+		d = -d
+	}
+	fmt.Fprintf(b, "%02d:%02d:%02d", d/time.Hour, d/time.Minute%60, d/time.Second%60)
+
+	frac := fmt.Sprintf("%09d", d%time.Second)
+	if fsp < 0 {
+		frac = strings.TrimRight(frac, "0")
+	} else if fsp < 9 {
+		frac = frac[:fsp]
+	}
+	if frac != "" {
+		b.WriteString("." + frac)
+	}
+	return b.String()
+}
+
+// NullYear is the scan type of YEAR columns, saved as integers.
+type NullYear struct {
+	Year  int
+	Valid bool
+}
+
+func (y *NullYear) Scan(v interface{}) error {
+	switch vv := v.(type) {
+	case nil:
+		*y = NullYear{}
+	case int64:
+		*y = NullYear{Year: int(vv), Valid: true}
+	case []byte:
+		n, err := strconv.Atoi(string(vv))
+		if err != nil {
+			return fmt.Errorf("invalid YEAR value %q", vv)
+		}
+		*y = NullYear{Year: n, Valid: true}
+	default:
+		return fmt.Errorf("unsupported YEAR value: %T", v)
+	}
+	return nil
+}
+
+func (y NullYear) Value() (driver.Value, error) {
+	if !y.Valid {
+		return nil, nil
+	}
+	return int64(y.Year), nil
+}
+
+func (y NullYear) String() string {
+	if !y.Valid {
+		return "NULL"
+	}
+	return strconv.Itoa(y.Year)
+}
+
+func init() {
+	RegisterScanType(reflect.TypeOf(NullDate{}), Codec{
+		Encode: func(v interface{}) (interface{}, error) {
+			d := v.(NullDate)
+			if !d.Valid {
+				return nil, nil
+			}
+			return d.String(), nil
+		},
+		Decode: func(v interface{}, _ reflect.Type) (interface{}, error) {
+			d := NullDate{}
+			if v == nil {
+				return d, nil
+			}
+			s, err := toString(v)
+			if err != nil {
+				return nil, err
+			}
+			err = d.Scan(s)
+			return d, err
+		},
+	})
+
+	RegisterScanType(reflect.TypeOf(NullDuration{}), Codec{
+		Encode: func(v interface{}) (interface{}, error) {
+			d := v.(NullDuration)
+			if !d.Valid {
+				return nil, nil
+			}
+			return d.String(), nil
+		},
+		Decode: func(v interface{}, _ reflect.Type) (interface{}, error) {
+			d := NullDuration{}
+			if v == nil {
+				return d, nil
+			}
+			s, err := toString(v)
+			if err != nil {
+				return nil, err
+			}
+			err = d.Scan(s)
+			return d, err
+		},
+	})
+
+	RegisterScanType(reflect.TypeOf(NullYear{}), Codec{
+		Encode: func(v interface{}) (interface{}, error) {
+			y := v.(NullYear)
+			if !y.Valid {
+				return nil, nil
+			}
+			return y.Year, nil
+		},
+		Decode: func(v interface{}, _ reflect.Type) (interface{}, error) {
+			if v == nil {
+				return NullYear{}, nil
+			}
+			n, err := toInt64(v)
+			return NullYear{Year: int(n), Valid: err == nil}, err
+		},
+	})
+}
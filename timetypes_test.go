@@ -0,0 +1,114 @@
+package dbtesting
+
+import (
+	"encoding/json"
+	"github.com/go-sql-driver/mysql"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizeTime(t *testing.T) {
+	loc := time.FixedZone("CST", 8*3600)
+	local := time.Date(2018, 12, 1, 9, 0, 0, 123456789, loc)
+
+	for _, v := range []struct {
+		databaseType string
+		expect       time.Time
+	}{
+		{"DATETIME", time.Date(2018, 12, 1, 9, 0, 0, 123000000, time.UTC)},
+		{"TIMESTAMP", time.Date(2018, 12, 1, 1, 0, 0, 123000000, time.UTC)},
+	} {
+		col := &ColType{name: "at", databaseType: v.databaseType, hasPrecisionScale: true, precision: 3, scale: 3, scanType: reflect.TypeOf(mysql.NullTime{})}
+		col.setTimeSemantics()
+
+		n := normalizeCell(mysql.NullTime{Time: local, Valid: true}, col).(mysql.NullTime)
+		if n.Time != v.expect {
+			t.Errorf("%s: normalized as %s, expect %s", v.databaseType, n.Time, v.expect)
+		}
+
+		e, err := encodeCell(n, col)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e != v.expect.Format("2006-01-02T15:04:05.000Z07:00") {
+			t.Errorf("%s: encoded as %v", v.databaseType, e)
+		}
+
+		// as saved by older versions on a machine in another zone
+		d, err := decodeCell(local.Format(time.RFC3339Nano), col)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, same := compareCell(d, n, col, &Query{}); !same {
+			t.Errorf("%s: %v decoded as %v", v.databaseType, local, d)
+		}
+	}
+}
+
+func TestTimeColumnHeader(t *testing.T) {
+	col := &ColType{name: "at", databaseType: "DATETIME", hasPrecisionScale: true, scale: 6, scanType: reflect.TypeOf(mysql.NullTime{})}
+	col.setTimeSemantics()
+
+	data, err := json.Marshal(col)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"DatetimePrecision":6,"Zone":"none"`) {
+		t.Errorf("unexpected header %s", data)
+	}
+
+	// older headers get the semantics from the database type
+	legacy := strings.Replace(string(data), `,"DatetimePrecision":6,"Zone":"none"`, "", 1)
+	c2 := &ColType{}
+	err = json.Unmarshal([]byte(legacy), c2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(col, c2) {
+		t.Errorf("%+v loaded as %+v", col, c2)
+	}
+
+	at := time.Date(2018, 12, 1, 9, 0, 0, 0, time.UTC)
+	if v := insertValue(at, col); v != "2018-12-01 09:00:00" {
+		t.Errorf("unexpected insert value %v", v)
+	}
+}
+
+func TestDateTypes(t *testing.T) {
+	for _, v := range []struct {
+		typ    reflect.Type
+		saved  interface{}
+		expect interface{}
+	}{
+		{reflect.TypeOf(NullDate{}), "2018-12-01", NullDate{Year: 2018, Month: 12, Day: 1, Valid: true}},
+		{reflect.TypeOf(NullDate{}), "0000-00-00", NullDate{Valid: true}},
+		{reflect.TypeOf(NullDuration{}), "-838:59:59.5", NullDuration{Duration: -(838*time.Hour + 59*time.Minute + 59*time.Second + 500*time.Millisecond), Valid: true}},
+		{reflect.TypeOf(NullDuration{}), "12:00:00", NullDuration{Duration: 12 * time.Hour, Valid: true}},
+		{reflect.TypeOf(NullYear{}), 2018, NullYear{Year: 2018, Valid: true}},
+	} {
+		d, err := decodeValue(v.saved, v.typ)
+		if err != nil || d != v.expect {
+			t.Errorf("%v decoded as %v, %v", v.saved, d, err)
+			continue
+		}
+		e, err := encodeValue(d, v.typ)
+		if err != nil || e != v.saved {
+			t.Errorf("%v encoded as %v, %v", d, e, err)
+		}
+	}
+
+	// the DATE of older snapshots, saved as a time
+	d, err := decodeValue("2018-12-01T00:00:00+08:00", reflect.TypeOf(NullDate{}))
+	if err != nil || d.(NullDate).String() != "2018-12-01" {
+		t.Errorf("unexpected date %v, %v", d, err)
+	}
+
+	col := &ColType{name: "t", databaseType: "TIME", hasPrecisionScale: true, scale: 2, scanType: reflect.TypeOf(NullDuration{})}
+	col.setTimeSemantics()
+	n := normalizeCell(NullDuration{Duration: 1234567 * time.Microsecond, Valid: true}, col)
+	if e, _ := encodeCell(n, col); e != "00:00:01.23" {
+		t.Errorf("unexpected TIME %v", e)
+	}
+}
@@ -0,0 +1,216 @@
+package dbtesting
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// UpdateSummary lists by name the results written by Snapshot.Update, or the
+// tables written by TT.UpdateSchema.
+type UpdateSummary struct {
+	Created []string
+	Updated []string
+	Removed []string
+}
+
+func (s *UpdateSummary) String() string {
+	var parts []string
+	for _, v := range []struct {
+		op    string
+		names []string
+	}{
+		{"created", s.Created},
+		{"updated", s.Updated},
+		{"removed", s.Removed},
+	} {
+		if len(v.names) > 0 {
+			parts = append(parts, v.op+" "+strings.Join(v.names, ", "))
+		}
+	}
+
+	if len(parts) == 0 {
+		return "unchanged"
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Update writes the results into the snapshot directory, leaving the files of
+// unchanged results untouched and removing the files of the results gone
+// since the previous save. It refuses to run when the directory holds files
+// the previous manifest does not list, rather than deleting them.
+func (s *Snapshot) Update() (*UpdateSummary, error) {
+	err := checkResultNames(s.results)
+	if err != nil {
+		return nil, err
+	}
+
+	path := s.path()
+	err = os.MkdirAll(path, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	prev, owned, err := ownedFiles(path)
+	if err != nil {
+		return nil, err
+	}
+
+	enc := s.encoding
+	if enc == nil {
+		enc = JSON
+	}
+
+	summary := &UpdateSummary{}
+	m := newManifest(s.results)
+	written := map[string]bool{ManifestFile: true}
+	for i, v := range s.results {
+		data, meta, err := enc.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+
+		file := escapeName(v.name) + enc.Ext()
+		changed, err := writeIfChanged(filepath.Join(path, file), data)
+		if err != nil {
+			return nil, err
+		}
+		m.Results[i].File = file
+		written[file] = true
+
+		if meta != nil {
+			m.Results[i].Meta = metaFile(file)
+			metaChanged, err := writeIfChanged(filepath.Join(path, m.Results[i].Meta), meta)
+			if err != nil {
+				return nil, err
+			}
+			changed = changed || metaChanged
+			written[m.Results[i].Meta] = true
+		}
+
+		pr, ok := prev[v.name]
+		switch {
+		case !ok:
+			summary.Created = append(summary.Created, v.name)
+		case changed || pr.File != file || pr.Meta != m.Results[i].Meta:
+			summary.Updated = append(summary.Updated, v.name)
+		}
+		delete(prev, v.name)
+	}
+
+	for file := range owned {
+		if written[file] {
+			continue
+		}
+		err = os.Remove(filepath.Join(path, file))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	for name := range prev {
+		summary.Removed = append(summary.Removed, name)
+	}
+	sort.Strings(summary.Removed)
+
+	// keep the creation time of a manifest listing the same results
+	old, err := LoadManifest(path)
+	if err == nil && reflect.DeepEqual(old.Results, m.Results) {
+		m.CreatedAt = old.CreatedAt
+	}
+
+	data, err := m.marshal()
+	if err != nil {
+		return nil, err
+	}
+	_, err = writeIfChanged(filepath.Join(path, ManifestFile), data)
+	if err != nil {
+		return nil, err
+	}
+
+	return summary, nil
+}
+
+// ownedFiles returns the results of the previous save of dir by name, and the
+// files it created. The files of a legacy snapshot are its results and their
+// sidecars. Files created by something else are an error.
+func ownedFiles(dir string) (map[string]ManifestResult, map[string]bool, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	files := make(map[string]bool, len(fis))
+	for _, v := range fis {
+		if !v.IsDir() {
+			files[v.Name()] = true
+		}
+	}
+
+	prev := make(map[string]ManifestResult)
+	owned := make(map[string]bool)
+
+	m, err := LoadManifest(dir)
+	switch {
+	case os.IsNotExist(err):
+		for file := range files {
+			if isSidecar(file, files) {
+				owned[file] = true
+				continue
+			}
+			r, err := loadLegacyResult(dir, file)
+			if err != nil {
+				continue
+			}
+			prev[r.name] = ManifestResult{File: file}
+			owned[file] = true
+		}
+
+	case err != nil:
+		return nil, nil, err
+
+	default:
+		owned[ManifestFile] = true
+		for _, v := range m.Results {
+			prev[v.Name] = v
+			owned[v.File] = true
+			if v.Meta != "" {
+				owned[v.Meta] = true
+			}
+		}
+	}
+
+	var foreign []string
+	for file := range files {
+		if !owned[file] {
+			foreign = append(foreign, file)
+		}
+	}
+	if len(foreign) > 0 {
+		sort.Strings(foreign)
+		if m == nil {
+			return nil, nil, fmt.Errorf("refuse to update %s: files %s are not results", dir, strings.Join(foreign, ", "))
+		}
+		return nil, nil, fmt.Errorf("refuse to update %s: files %s are not listed by its manifest", dir, strings.Join(foreign, ", "))
+	}
+
+	return prev, owned, nil
+}
+
+// writeIfChanged writes data to path unless the file already holds it, and
+// reports whether it wrote.
+func writeIfChanged(path string, data []byte) (bool, error) {
+	old, err := ioutil.ReadFile(path)
+	if err == nil && bytes.Equal(old, data) {
+		return false, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	return true, ioutil.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,128 @@
+package dbtesting
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshotUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "update")
+	s := &Snapshot{
+		name:     "update",
+		testName: t.Name(),
+		dir:      path,
+		results: []*Result{
+			newTestResult("a", []string{"id"}, []interface{}{int64(1)}),
+			newTestResult("b", []string{"id"}, []interface{}{int64(2)}),
+		},
+	}
+
+	summary, err := s.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.String() != "created a, b" {
+		t.Errorf("unexpected summary: %s", summary)
+	}
+
+	old := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, f := range []string{"a.json", "b.json", ManifestFile} {
+		err = os.Chtimes(filepath.Join(path, f), old, old)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	summary, err = s.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.String() != "unchanged" {
+		t.Errorf("unexpected summary: %s", summary)
+	}
+	for _, f := range []string{"a.json", "b.json", ManifestFile} {
+		fi, err := os.Stat(filepath.Join(path, f))
+		if err != nil || !fi.ModTime().Equal(old) {
+			t.Errorf("%s should be untouched: %v", f, err)
+		}
+	}
+
+	s.results = []*Result{
+		newTestResult("b", []string{"id"}, []interface{}{int64(3)}),
+		newTestResult("c", []string{"id"}, []interface{}{int64(4)}),
+	}
+	summary, err = s.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.String() != "created c; updated b; removed a" {
+		t.Errorf("unexpected summary: %s", summary)
+	}
+	if _, err = os.Stat(filepath.Join(path, "a.json")); !os.IsNotExist(err) {
+		t.Errorf("a.json should be removed: %v", err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(path, "notes.txt"), []byte("hand-written"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Update()
+	if err == nil || !strings.Contains(err.Error(), "files notes.txt are not listed") {
+		t.Errorf("expect refusal, got %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(path, "notes.txt")); err != nil {
+		t.Errorf("notes.txt should be kept: %v", err)
+	}
+}
+
+func TestLegacySnapshotUpdate(t *testing.T) {
+	path := t.TempDir()
+	results := []*Result{
+		newTestResult("users", []string{"id"}, []interface{}{int64(1)}),
+		newTestResult("db.tab", []string{"id"}, []interface{}{int64(2)}),
+	}
+	for _, r := range results {
+		data, err := Marshal(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filepath.Join(path, r.name), data, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := ioutil.WriteFile(filepath.Join(path, "NOTES.md"), []byte("hand-written"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Snapshot{name: "legacy", dir: path, results: results}
+	_, err = s.Update()
+	if err == nil || !strings.Contains(err.Error(), "files NOTES.md are not results") {
+		t.Errorf("expect refusal, got %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(path, "NOTES.md")); err != nil {
+		t.Errorf("NOTES.md should be kept: %v", err)
+	}
+
+	err = os.Remove(filepath.Join(path, "NOTES.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary, err := s.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.String() != "updated users, db.tab" {
+		t.Errorf("unexpected summary: %s", summary)
+	}
+	for _, f := range []string{"users", "db.tab"} {
+		if _, err = os.Stat(filepath.Join(path, f)); !os.IsNotExist(err) {
+			t.Errorf("%s should be replaced: %v", f, err)
+		}
+	}
+}
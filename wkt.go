@@ -0,0 +1,396 @@
+package dbtesting
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// WKB geometry types, 2D only.
+const (
+	wkbPoint = iota + 1
+	wkbLineString
+	wkbPolygon
+	wkbMultiPoint
+	wkbMultiLineString
+	wkbMultiPolygon
+	wkbGeometryCollection
+)
+
+var wkbNames = map[uint32]string{
+	wkbPoint:              "POINT",
+	wkbLineString:         "LINESTRING",
+	wkbPolygon:            "POLYGON",
+	wkbMultiPoint:         "MULTIPOINT",
+	wkbMultiLineString:    "MULTILINESTRING",
+	wkbMultiPolygon:       "MULTIPOLYGON",
+	wkbGeometryCollection: "GEOMETRYCOLLECTION",
+}
+
+// wkbToWKT formats well-known binary as well-known text.
+func wkbToWKT(wkb []byte) (string, error) {
+	r := &wkbReader{r: bytes.NewReader(wkb)}
+	b := &strings.Builder{}
+	r.geometry(b)
+	if r.err == nil && r.r.Len() > 0 {
+		r.err = errors.New("trailing bytes")
+	}
+	if r.err != nil {
+		return "", fmt.Errorf("invalid WKB: %s", r.err)
+	}
+	return b.String(), nil
+}
+
+type wkbReader struct {
+	r     *bytes.Reader
+	order binary.ByteOrder
+	err   error
+}
+
+func (r *wkbReader) uint32() uint32 {
+	var v uint32
+	if r.err == nil {
+		r.err = binary.Read(r.r, r.order, &v)
+	}
+	return v
+}
+
+func (r *wkbReader) float64() float64 {
+	var v float64
+	if r.err == nil {
+		r.err = binary.Read(r.r, r.order, &v)
+	}
+	return v
+}
+
+func (r *wkbReader) header() uint32 {
+	if r.err != nil {
+		return 0
+	}
+
+	o, err := r.r.ReadByte()
+	if err != nil {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	switch o {
+	case 0:
+		r.order = binary.BigEndian
+	case 1:
+		r.order = binary.LittleEndian
+	default:
+		r.err = fmt.Errorf("invalid byte order %d", o)
+		return 0
+	}
+
+	typ := r.uint32()
+	if _, ok := wkbNames[typ]; !ok && r.err == nil {
+		r.err = fmt.Errorf("unsupported geometry type %d", typ)
+	}
+	return typ
+}
+
+// count reads a number of elements, bounded by the remaining bytes.
+func (r *wkbReader) count() int {
+	n := r.uint32()
+	if r.err == nil && int64(n) > int64(r.r.Len()) {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	return int(n)
+}
+
+func (r *wkbReader) geometry(b *strings.Builder) {
+	typ := r.header()
+	if r.err != nil {
+		return
+	}
+	b.WriteString(wkbNames[typ])
+
+	switch typ {
+	case wkbPoint:
+		x, y := r.float64(), r.float64()
+		if math.IsNaN(x) && math.IsNaN(y) {
+			b.WriteString(" EMPTY")
+			return
+		}
+		b.WriteByte('(')
+		writeCoord(b, x, y)
+		b.WriteByte(')')
+	case wkbLineString:
+		r.points(b)
+	case wkbPolygon:
+		r.rings(b)
+	default:
+		n := r.count()
+		if n == 0 {
+			b.WriteString(" EMPTY")
+			return
+		}
+
+		b.WriteByte('(')
+		for i := 0; i < n && r.err == nil; i++ {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+
+			sub := &strings.Builder{}
+			r.geometry(sub)
+			s := sub.String()
+			if typ != wkbGeometryCollection {
+				// the members of multi geometries go without their type
+				s = strings.TrimPrefix(s, wkbNames[typ-3])
+			}
+			b.WriteString(s)
+		}
+		b.WriteByte(')')
+	}
+}
+
+func (r *wkbReader) points(b *strings.Builder) {
+	n := r.count()
+	if n == 0 {
+		b.WriteString(" EMPTY")
+		return
+	}
+
+	b.WriteByte('(')
+	for i := 0; i < n && r.err == nil; i++ {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		writeCoord(b, r.float64(), r.float64())
+	}
+	b.WriteByte(')')
+}
+
+func (r *wkbReader) rings(b *strings.Builder) {
+	n := r.count()
+	if n == 0 {
+		b.WriteString(" EMPTY")
+		return
+	}
+
+	b.WriteByte('(')
+	for i := 0; i < n && r.err == nil; i++ {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		r.points(b)
+	}
+	b.WriteByte(')')
+}
+
+func writeCoord(b *strings.Builder, x, y float64) {
+	b.WriteString(strconv.FormatFloat(x, 'f', -1, 64))
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatFloat(y, 'f', -1, 64))
+}
+
+// wktToWKB parses well-known text into little-endian well-known binary.
+func wktToWKB(wkt string) ([]byte, error) {
+	p := &wktParser{s: wkt}
+	wkb, err := p.geometry()
+	if err == nil {
+		p.skipSpace()
+		if p.pos < len(p.s) {
+			err = fmt.Errorf("unexpected %q", p.s[p.pos:])
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid WKT %q: %s", wkt, err)
+	}
+	return wkb, nil
+}
+
+type wktParser struct {
+	s   string
+	pos int
+}
+
+func (p *wktParser) skipSpace() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t' || p.s[p.pos] == '\n') {
+		p.pos++
+	}
+}
+
+func (p *wktParser) peek() byte {
+	p.skipSpace()
+	if p.pos < len(p.s) {
+		return p.s[p.pos]
+	}
+	return 0
+}
+
+func (p *wktParser) expect(c byte) error {
+	if p.peek() != c {
+		return fmt.Errorf("expect %q at %d", c, p.pos)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *wktParser) word() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.s) && (p.s[p.pos] >= 'A' && p.s[p.pos] <= 'Z' || p.s[p.pos] >= 'a' && p.s[p.pos] <= 'z') {
+		p.pos++
+	}
+	return strings.ToUpper(p.s[start:p.pos])
+}
+
+// empty consumes the EMPTY keyword if present.
+func (p *wktParser) empty() bool {
+	save := p.pos
+	if p.word() == "EMPTY" {
+		return true
+	}
+	p.pos = save
+	return false
+}
+
+func (p *wktParser) number() (float64, error) {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.s) && strings.IndexByte("0123456789+-.eE", p.s[p.pos]) >= 0 {
+		p.pos++
+	}
+	return strconv.ParseFloat(p.s[start:p.pos], 64)
+}
+
+func (p *wktParser) coord(w *bytes.Buffer) error {
+	for i := 0; i < 2; i++ {
+		f, err := p.number()
+		if err != nil {
+			return err
+		}
+		binary.Write(w, binary.LittleEndian, f)
+	}
+	return nil
+}
+
+// list parses a parenthesized list of elements, writing their count then
+// their content.
+func (p *wktParser) list(w *bytes.Buffer, elem func(*bytes.Buffer) error) error {
+	if p.empty() {
+		binary.Write(w, binary.LittleEndian, uint32(0))
+		return nil
+	}
+
+	err := p.expect('(')
+	if err != nil {
+		return err
+	}
+
+	body := &bytes.Buffer{}
+	n := uint32(0)
+	for {
+		err = elem(body)
+		if err != nil {
+			return err
+		}
+		n++
+
+		if p.peek() != ',' {
+			break
+		}
+		p.pos++
+	}
+
+	err = p.expect(')')
+	if err != nil {
+		return err
+	}
+
+	binary.Write(w, binary.LittleEndian, n)
+	w.Write(body.Bytes())
+	return nil
+}
+
+func (p *wktParser) points(w *bytes.Buffer) error {
+	return p.list(w, p.coord)
+}
+
+func (p *wktParser) rings(w *bytes.Buffer) error {
+	return p.list(w, p.points)
+}
+
+func (p *wktParser) geometry() ([]byte, error) {
+	name := p.word()
+	typ := uint32(0)
+	for t, n := range wkbNames {
+		if n == name {
+			typ = t
+		}
+	}
+	if typ == 0 {
+		return nil, fmt.Errorf("unsupported geometry %q", name)
+	}
+
+	w := &bytes.Buffer{}
+	w.WriteByte(1)
+	binary.Write(w, binary.LittleEndian, typ)
+
+	// member writes an element of a multi geometry as a geometry of its own
+	member := func(typ uint32, body func(*bytes.Buffer) error) func(*bytes.Buffer) error {
+		return func(w *bytes.Buffer) error {
+			w.WriteByte(1)
+			binary.Write(w, binary.LittleEndian, typ)
+			return body(w)
+		}
+	}
+
+	var err error
+	switch typ {
+	case wkbPoint:
+		if p.empty() {
+			binary.Write(w, binary.LittleEndian, math.NaN())
+			binary.Write(w, binary.LittleEndian, math.NaN())
+			break
+		}
+		err = p.expect('(')
+		if err == nil {
+			err = p.coord(w)
+		}
+		if err == nil {
+			err = p.expect(')')
+		}
+	case wkbLineString:
+		err = p.points(w)
+	case wkbPolygon:
+		err = p.rings(w)
+	case wkbMultiPoint:
+		err = p.list(w, member(wkbPoint, func(w *bytes.Buffer) error {
+			// points of a MULTIPOINT may be parenthesized or not
+			if p.peek() != '(' {
+				return p.coord(w)
+			}
+			p.pos++
+			err := p.coord(w)
+			if err != nil {
+				return err
+			}
+			return p.expect(')')
+		}))
+	case wkbMultiLineString:
+		err = p.list(w, member(wkbLineString, p.points))
+	case wkbMultiPolygon:
+		err = p.list(w, member(wkbPolygon, p.rings))
+	case wkbGeometryCollection:
+		err = p.list(w, func(w *bytes.Buffer) error {
+			g, err := p.geometry()
+			w.Write(g)
+			return err
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return w.Bytes(), nil
+}